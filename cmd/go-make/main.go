@@ -0,0 +1,378 @@
+// Command go-make is a GNU make compatible build tool.
+//
+// Usage:
+//
+//	go-make [options] [VAR=value ...] [target ...]
+//
+// Options:
+//
+//	-f FILE, --file=FILE         Read FILE as the Makefile
+//	-C DIR, --directory=DIR      Change to DIR before doing anything
+//	-e, --environment-overrides  Environment variables override Makefile assignments
+//	-p, --print-data-base        Print the variables and rules before building
+//	-r, --no-builtin-rules       Disable the built-in implicit rules
+//	-R, --no-builtin-variables   Disable the built-in variable settings
+//	-n, --dry-run                Print the commands instead of running them
+//	-t, --touch                  Touch targets instead of remaking them
+//	-q, --question               Run nothing; exit 1 if a target is out of date
+//	-B, --always-make            Consider every target out of date
+//	-W FILE, --what-if=FILE      Consider FILE to be infinitely new
+//	-o FILE, --old-file=FILE     Consider FILE to be very old and don't remake it
+//	-k, --keep-going             Keep going when some targets can't be made
+//	-j [N], --jobs[=N]           Run N recipes at once; without N, no limit
+//	--jobserver-style=STYLE      Share the -j limit through a pipe or a fifo
+//	-h, --help                   Print this message and exit
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/5l0p/go-make/pkg/builder"
+	"github.com/5l0p/go-make/pkg/cmd"
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+const usage = `Usage: go-make [options] [VAR=value ...] [target ...]
+Options:
+  -f FILE, --file=FILE         Read FILE as the Makefile
+  -C DIR, --directory=DIR      Change to DIR before doing anything
+  -e, --environment-overrides  Environment variables override Makefile assignments
+  -p, --print-data-base        Print the variables and rules before building
+  -r, --no-builtin-rules       Disable the built-in implicit rules
+  -R, --no-builtin-variables   Disable the built-in variable settings
+  -n, --dry-run                Print the commands instead of running them
+  -t, --touch                  Touch targets instead of remaking them
+  -q, --question               Run nothing; exit 1 if a target is out of date
+  -B, --always-make            Consider every target out of date
+  -W FILE, --what-if=FILE      Consider FILE to be infinitely new
+  -o FILE, --old-file=FILE     Consider FILE to be very old and don't remake it
+  -k, --keep-going             Keep going when some targets can't be made
+  -j [N], --jobs[=N]           Run N recipes at once; without N, no limit
+  --jobserver-style=STYLE      Share the -j limit through a pipe or a fifo
+  -h, --help                   Print this message and exit
+`
+
+// config holds the parsed command line.
+type config struct {
+	file                 string
+	dir                  string
+	environmentOverrides bool
+	printDatabase        bool
+	noBuiltinRules       bool
+	noBuiltinVariables   bool
+	keepGoing            bool
+	dryRun               bool
+	touch                bool
+	question             bool
+	alwaysMake           bool
+	newFiles             []string
+	oldFiles             []string
+	jobs                 int
+	jobserverStyle       string
+	assignments          []string
+	targets              []string
+	help                 bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes go-make with the given arguments and returns the exit status.
+func run(args []string) int {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "go-make: %v\n%s", err, usage)
+		return 2
+	}
+	if cfg.help {
+		fmt.Print(usage)
+		return 0
+	}
+
+	// $(MAKE) must keep working after -C, so a relative program path is
+	// made absolute first
+	program := os.Args[0]
+	if strings.ContainsRune(program, '/') {
+		if abs, err := filepath.Abs(program); err == nil {
+			program = abs
+		}
+	}
+
+	if cfg.dir != "" {
+		if err := os.Chdir(cfg.dir); err != nil {
+			fmt.Fprintf(os.Stderr, "go-make: %v\n", err)
+			return 2
+		}
+	}
+
+	// Options inherited from a parent make come first, so that our own
+	// command line takes precedence
+	opts := []cmd.Option{
+		cmd.WithMakeFlags(os.Getenv("MAKEFLAGS")),
+		cmd.WithProgram(program),
+		cmd.WithGoals(cfg.targets...),
+	}
+	if cfg.environmentOverrides {
+		opts = append(opts, cmd.WithEnvironmentOverrides())
+	}
+	if cfg.noBuiltinRules {
+		opts = append(opts, cmd.WithoutBuiltinRules())
+	}
+	if cfg.noBuiltinVariables {
+		opts = append(opts, cmd.WithoutBuiltinVariables())
+	}
+	if cfg.dryRun {
+		opts = append(opts, cmd.WithDryRun())
+	}
+	if cfg.touch {
+		opts = append(opts, cmd.WithTouch())
+	}
+	if cfg.question {
+		opts = append(opts, cmd.WithQuestion())
+	}
+	if cfg.alwaysMake {
+		opts = append(opts, cmd.WithAlwaysMake())
+	}
+	if len(cfg.newFiles) > 0 {
+		opts = append(opts, cmd.WithWhatIf(cfg.newFiles...))
+	}
+	if len(cfg.oldFiles) > 0 {
+		opts = append(opts, cmd.WithOldFiles(cfg.oldFiles...))
+	}
+	if cfg.keepGoing {
+		opts = append(opts, cmd.WithKeepGoing())
+	}
+	if cfg.jobs != 1 {
+		opts = append(opts, cmd.WithJobs(cfg.jobs))
+	}
+	if cfg.jobserverStyle != "" {
+		opts = append(opts, cmd.WithJobserverStyle(cfg.jobserverStyle))
+	}
+	opts = append(opts, cmd.WithAssignments(cfg.assignments...))
+
+	make, err := cmd.New(cfg.file, opts...)
+	if err != nil {
+		report(err)
+		return 2
+	}
+	defer make.Close()
+
+	if cfg.printDatabase {
+		if err := make.PrintDatabase(os.Stdout); err != nil {
+			report(err)
+			return 2
+		}
+	}
+
+	// An interrupt stops the running recipes, which are in process groups
+	// of their own and so do not receive it from the terminal. A second
+	// one kills go-make outright.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	err = make.BuildContext(ctx, cfg.targets...)
+	if errors.Is(err, builder.ErrNotUpToDate) {
+		// -q answers with the exit status alone
+		return 1
+	}
+	if err != nil {
+		report(err)
+		return 2
+	}
+	return 0
+}
+
+// parseArgs parses GNU make style arguments. Options and their values may
+// be combined ("-ef Makefile", "-fMakefile") and may be mixed with variable
+// assignments and targets.
+func parseArgs(args []string) (*config, error) {
+	cfg := &config{jobs: 1}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--":
+			cfg.targets = append(cfg.targets, args[i+1:]...)
+			return cfg, nil
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			takeValue := func() (string, error) {
+				if hasValue {
+					return value, nil
+				}
+				if i+1 >= len(args) {
+					return "", fmt.Errorf("option '--%s' requires an argument", name)
+				}
+				i++
+				return args[i], nil
+			}
+
+			var err error
+			switch name {
+			case "file", "makefile":
+				cfg.file, err = takeValue()
+			case "directory":
+				cfg.dir, err = takeValue()
+			case "environment-overrides":
+				cfg.environmentOverrides = true
+			case "print-data-base":
+				cfg.printDatabase = true
+			case "no-builtin-rules":
+				cfg.noBuiltinRules = true
+			case "no-builtin-variables":
+				cfg.noBuiltinVariables = true
+			case "dry-run", "just-print", "recon":
+				cfg.dryRun = true
+			case "touch":
+				cfg.touch = true
+			case "question":
+				cfg.question = true
+			case "always-make":
+				cfg.alwaysMake = true
+			case "what-if", "new-file", "assume-new":
+				var file string
+				file, err = takeValue()
+				cfg.newFiles = append(cfg.newFiles, file)
+			case "old-file", "assume-old":
+				var file string
+				file, err = takeValue()
+				cfg.oldFiles = append(cfg.oldFiles, file)
+			case "keep-going":
+				cfg.keepGoing = true
+			case "jobs":
+				cfg.jobs = 0
+				if hasValue {
+					cfg.jobs, err = parseJobs(value)
+				}
+			case "jobserver-style":
+				cfg.jobserverStyle, err = takeValue()
+			case "help":
+				cfg.help = true
+			default:
+				err = fmt.Errorf("unrecognized option '%s'", arg)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				// Options with a value take the rest of the word, or
+				// the next argument
+				takeValue := func() (string, error) {
+					if j+1 < len(arg) {
+						value := arg[j+1:]
+						j = len(arg)
+						return value, nil
+					}
+					if i+1 >= len(args) {
+						return "", fmt.Errorf("option requires an argument -- '%c'", arg[j])
+					}
+					i++
+					return args[i], nil
+				}
+
+				var err error
+				switch arg[j] {
+				case 'f':
+					cfg.file, err = takeValue()
+				case 'C':
+					cfg.dir, err = takeValue()
+				case 'e':
+					cfg.environmentOverrides = true
+				case 'p':
+					cfg.printDatabase = true
+				case 'r':
+					cfg.noBuiltinRules = true
+				case 'R':
+					cfg.noBuiltinVariables = true
+				case 'n':
+					cfg.dryRun = true
+				case 't':
+					cfg.touch = true
+				case 'q':
+					cfg.question = true
+				case 'B':
+					cfg.alwaysMake = true
+				case 'W':
+					var file string
+					file, err = takeValue()
+					cfg.newFiles = append(cfg.newFiles, file)
+				case 'o':
+					var file string
+					file, err = takeValue()
+					cfg.oldFiles = append(cfg.oldFiles, file)
+				case 'k':
+					cfg.keepGoing = true
+				case 'j':
+					// The job count is optional: it is the rest of the
+					// word or the next argument, if that is a number
+					cfg.jobs = 0
+					if j+1 < len(arg) {
+						cfg.jobs, err = parseJobs(arg[j+1:])
+						j = len(arg)
+					} else if i+1 < len(args) && isNumber(args[i+1]) {
+						i++
+						cfg.jobs, err = parseJobs(args[i])
+					}
+				case 'h':
+					cfg.help = true
+				default:
+					err = fmt.Errorf("invalid option -- '%c'", arg[j])
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		case types.IsVariableAssignment(arg):
+			cfg.assignments = append(cfg.assignments, arg)
+		default:
+			cfg.targets = append(cfg.targets, arg)
+		}
+	}
+	return cfg, nil
+}
+
+// parseJobs parses the argument of -j, which must be a positive number.
+func parseJobs(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("the '-j' option requires a positive integer argument")
+	}
+	return n, nil
+}
+
+// isNumber reports whether s consists only of decimal digits.
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// report prints a build error in the style of GNU make. Errors listing
+// several failures, as keep-going builds return, get a line for each.
+func report(err error) {
+	var makeErr *types.Error
+	if errors.As(err, &makeErr) {
+		fmt.Fprintln(os.Stderr, makeErr)
+		return
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "go-make: *** %s\n", line)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want config
+	}{
+		{"no arguments", nil, config{jobs: 1}},
+		{"combined short flags", []string{"-ef", "Makefile.alt"}, config{environmentOverrides: true, file: "Makefile.alt", jobs: 1}},
+		{"value in the same word", []string{"-fMakefile.alt"}, config{file: "Makefile.alt", jobs: 1}},
+		{"directory and file", []string{"-C", "dir", "-f", "file"}, config{dir: "dir", file: "file", jobs: 1}},
+		{"-j with a count", []string{"-j", "4", "all"}, config{jobs: 4, targets: []string{"all"}}},
+		{"-j followed by a target", []string{"-j", "all"}, config{jobs: 0, targets: []string{"all"}}},
+		{"-j combined with other flags", []string{"-kj4"}, config{keepGoing: true, jobs: 4}},
+		{"--jobs without a value", []string{"--jobs", "4"}, config{jobs: 0, targets: []string{"4"}}},
+		{"--jobs with a value", []string{"--jobs=3"}, config{jobs: 3}},
+		{"long option with =", []string{"--file=Makefile.alt"}, config{file: "Makefile.alt", jobs: 1}},
+		{"long option with the next word", []string{"--directory", "dir", "all"}, config{dir: "dir", jobs: 1, targets: []string{"all"}}},
+		{"repeated file options", []string{"-W", "a.c", "--what-if=b.c", "-o", "c.h"}, config{newFiles: []string{"a.c", "b.c"}, oldFiles: []string{"c.h"}, jobs: 1}},
+		{
+			"variables mixed with targets",
+			[]string{"CC=clang", "all", "CFLAGS+=-g", "check"},
+			config{jobs: 1, assignments: []string{"CC=clang", "CFLAGS+=-g"}, targets: []string{"all", "check"}},
+		},
+		{"-- ends the options", []string{"-k", "--", "-n", "CC=clang"}, config{keepGoing: true, jobs: 1, targets: []string{"-n", "CC=clang"}}},
+		{"single dash is a target", []string{"-"}, config{jobs: 1, targets: []string{"-"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := parseArgs(test.args)
+			if err != nil {
+				t.Fatalf("parseArgs(%q) failed: %v", test.args, err)
+			}
+			if !reflect.DeepEqual(*cfg, test.want) {
+				t.Errorf("parseArgs(%q) = %+v, want %+v", test.args, *cfg, test.want)
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-f"}, "option requires an argument -- 'f'"},
+		{[]string{"-k", "-C"}, "option requires an argument -- 'C'"},
+		{[]string{"--file"}, "option '--file' requires an argument"},
+		{[]string{"-x"}, "invalid option -- 'x'"},
+		{[]string{"--frobnicate"}, "unrecognized option '--frobnicate'"},
+		{[]string{"-j0"}, "positive integer"},
+		{[]string{"--jobs=many"}, "positive integer"},
+	}
+	for _, test := range tests {
+		_, err := parseArgs(test.args)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseArgs(%q) error = %v, want %q", test.args, err, test.want)
+		}
+	}
+}
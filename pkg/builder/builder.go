@@ -2,9 +2,13 @@
 package builder
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"os"
-	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/5l0p/go-make/pkg/types"
 )
@@ -15,6 +19,194 @@ type Builder struct {
 	makefile *types.Makefile
 	built    map[string]bool
 	building map[string]bool
+
+	// implicit caches the implicit rules found for targets, intermediate
+	// holds the files those rules chain through, and mentioned holds the
+	// names that appear in explicit rules
+	implicit     map[string]*types.Rule
+	intermediate map[string]bool
+	mentioned    map[string]bool
+
+	// jobs is the number of recipes that may run at once; zero or less
+	// means no limit. A jobserver, if set, takes its place.
+	jobs      int
+	jobserver *Jobserver
+
+	// keepGoing carries on after a failure with the targets that do not
+	// depend on the failed one
+	keepGoing bool
+
+	// dryRun prints recipes instead of running them, and mtimes holds the
+	// modification times simulated for the targets it remade
+	dryRun bool
+	mtimes map[string]time.Time
+
+	// touch, question and alwaysMake change what is done with targets
+	// that are out of date, or which targets are
+	touch      bool
+	question   bool
+	alwaysMake bool
+
+	// newFiles and oldFiles override the timestamps of files: the former
+	// count as just modified, the latter as very old and never remade
+	newFiles map[string]bool
+	oldFiles map[string]bool
+
+	// executor runs the commands of recipes
+	executor Executor
+
+	// stdout receives the output of recipes together with the commands
+	// echoed and the progress messages, and stderr their errors
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// ErrNotUpToDate is returned by a Builder in question mode when a target
+// needs to be remade.
+var ErrNotUpToDate = errors.New("target is not up to date")
+
+// Option configures a Builder created by NewBuilder.
+type Option func(*Builder)
+
+// WithJobs lets the Builder run up to n recipes at once, like make's -j
+// option. If n is zero or negative, the number of recipes run at once is
+// not limited. Without this option recipes run one at a time.
+func WithJobs(n int) Option {
+	return func(b *Builder) {
+		b.jobs = n
+	}
+}
+
+// WithJobserver makes the Builder take job tokens from j, so that the
+// number of recipes run at once is shared with the other processes using
+// the jobserver. As in GNU make, only recursive recipe lines, those that
+// run $(MAKE) or are prefixed with '+', inherit the jobserver, so that
+// other commands cannot take or hold its tokens.
+func WithJobserver(j *Jobserver) Option {
+	return func(b *Builder) {
+		b.jobserver = j
+	}
+}
+
+// WithKeepGoing makes the Builder carry on after a recipe fails, like
+// make's -k option. Targets that depend on a failed target are not remade;
+// all others are, and Build returns a *BuildError listing the failures.
+func WithKeepGoing() Option {
+	return func(b *Builder) {
+		b.keepGoing = true
+	}
+}
+
+// WithDryRun makes the Builder print the commands it would run instead of
+// running them, like make's -n option. Targets it would remake count as
+// just modified, so that the targets depending on them are shown too.
+// Lines prefixed with '+' and lines that run $(MAKE) are still run, so
+// that sub-makes can print their own commands.
+func WithDryRun() Option {
+	return func(b *Builder) {
+		b.dryRun = true
+	}
+}
+
+// WithTouch makes the Builder touch the targets that are out of date
+// instead of running their recipes, like make's -t option, so that they
+// count as up to date. Lines prefixed with '+' and lines that run $(MAKE)
+// are still run.
+func WithTouch() Option {
+	return func(b *Builder) {
+		b.touch = true
+	}
+}
+
+// WithQuestion makes the Builder run nothing, like make's -q option. Build
+// returns ErrNotUpToDate as soon as it finds a target whose recipe would
+// run, and nil if everything is up to date. Nothing is printed.
+func WithQuestion() Option {
+	return func(b *Builder) {
+		b.question = true
+	}
+}
+
+// WithAlwaysMake makes the Builder treat every target as out of date, like
+// make's -B option.
+func WithAlwaysMake() Option {
+	return func(b *Builder) {
+		b.alwaysMake = true
+	}
+}
+
+// WithWhatIf makes the Builder act as if files had just been modified,
+// like make's -W option, without changing them. Combined with WithDryRun it
+// shows what editing the files would cause to be remade.
+func WithWhatIf(files ...string) Option {
+	return func(b *Builder) {
+		if b.newFiles == nil {
+			b.newFiles = make(map[string]bool)
+		}
+		for _, file := range files {
+			b.newFiles[file] = true
+		}
+	}
+}
+
+// WithOldFiles makes the Builder treat files as very old, like make's -o
+// option: they are never remade, nor are their prerequisites, and nothing
+// is remade because of them.
+func WithOldFiles(files ...string) Option {
+	return func(b *Builder) {
+		if b.oldFiles == nil {
+			b.oldFiles = make(map[string]bool)
+		}
+		for _, file := range files {
+			b.oldFiles[file] = true
+		}
+	}
+}
+
+// WithExecutor makes the Builder run the commands of recipes with e instead
+// of as local processes.
+func WithExecutor(e Executor) Option {
+	return func(b *Builder) {
+		b.executor = e
+	}
+}
+
+// WithOutput sends the output of recipes, the commands echoed and the
+// progress messages of the Builder to stdout, and the errors of recipes to
+// stderr, instead of the standard streams. The writers need not be safe
+// for concurrent use: the Builder serializes its writes to them, and
+// stdout and stderr may be the same writer.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(b *Builder) {
+		b.stdout, b.stderr = SyncOutput(stdout, stderr)
+	}
+}
+
+// SyncOutput returns writers that pass their writes on to stdout and stderr
+// one at a time, so that the output of a Builder given them with WithOutput
+// may be written to while it runs. A pair of writers that SyncOutput
+// returned is returned unchanged, so that they are not locked twice.
+func SyncOutput(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	out, outOK := stdout.(*syncWriter)
+	errs, errsOK := stderr.(*syncWriter)
+	if outOK && errsOK && out.mu == errs.mu {
+		return stdout, stderr
+	}
+	var mu sync.Mutex
+	return &syncWriter{mu: &mu, w: stdout}, &syncWriter{mu: &mu, w: stderr}
+}
+
+// syncWriter serializes the writes to w with those of the other
+// syncWriters sharing mu.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
 }
 
 // NewBuilder creates a new Builder instance for the given Makefile.
@@ -30,73 +222,72 @@ type Builder struct {
 //   if err != nil {
 //       log.Fatal(err)
 //   }
-func NewBuilder(makefile *types.Makefile) *Builder {
-	return &Builder{
-		makefile: makefile,
-		built:    make(map[string]bool),
-		building: make(map[string]bool),
+func NewBuilder(makefile *types.Makefile, opts ...Option) *Builder {
+	b := &Builder{
+		makefile:     makefile,
+		built:        make(map[string]bool),
+		building:     make(map[string]bool),
+		implicit:     make(map[string]*types.Rule),
+		intermediate: make(map[string]bool),
+		mtimes:       make(map[string]time.Time),
+		jobs:         1,
+		executor:     ProcessExecutor{},
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 // Build builds the specified target and all its dependencies.
 // It will:
-//   - Resolve dependencies recursively
+//   - Resolve dependencies into a graph, detecting circular dependencies
+//   - Find an implicit rule for targets without a recipe
 //   - Check file timestamps to determine if rebuilding is needed
-//   - Execute commands for targets that need rebuilding
-//   - Detect circular dependencies
+//   - Execute the recipes of targets that need rebuilding, running as
+//     many at once as the job limit allows
+//
+// Prerequisites inherit the target-specific variables of the target that
+// first causes them to be built.
 //
 // Returns an error if:
 //   - A circular dependency is detected
 //   - A target has no rule and doesn't exist as a file
 //   - A command execution fails
 func (b *Builder) Build(target string) error {
-	// If already built, skip
-	if b.built[target] {
-		return nil
-	}
-
-	// Detect circular dependencies
-	if b.building[target] {
-		return fmt.Errorf("circular dependency detected involving target '%s'", target)
-	}
-
-	rule, exists := b.makefile.Rules[target]
-	if !exists {
-		// If no rule exists, check if it's a file
-		if b.fileExists(target) {
-			return nil
-		}
-		return fmt.Errorf("no rule to make target '%s'", target)
-	}
+	return b.BuildContext(context.Background(), target)
+}
 
-	// Mark as currently building
-	b.building[target] = true
+// BuildContext builds the specified targets and all their dependencies,
+// as Build does, as one build: with more than one job, the recipes of
+// different targets may run at once.
+//
+// When ctx is canceled, no further recipes are started and the running
+// ones are stopped together with the processes they started. A target
+// whose recipe was stopped after it modified the target file has the file
+// deleted, as GNU make does when interrupted, so that the next build
+// remakes it. BuildContext returns once every recipe has stopped, with a
+// *CanceledError. Targets built until then stay built, and the Builder
+// may be used for further builds.
+//
+// Files made only to chain implicit rules, such as foo.c made from foo.y
+// to make foo.o, are deleted at the end, unless they are prerequisites of
+// .PRECIOUS or .SECONDARY.
+func (b *Builder) BuildContext(ctx context.Context, targets ...string) error {
+	b.building = make(map[string]bool)
 
-	// Build all dependencies first
-	for _, dep := range rule.Dependencies {
-		if err := b.Build(dep); err != nil {
+	var order []*node
+	nodes := make(map[string]*node)
+	for _, target := range targets {
+		if _, err := b.plan(target, nil, nodes, &order); err != nil {
 			return err
 		}
 	}
-
-	// Check if target needs rebuilding
-	if b.needsRebuild(target, rule.Dependencies) {
-		fmt.Printf("Building target: %s\n", target)
-		
-		// Create automatic variables context
-		autoVars := b.createAutomaticVariables(target, rule.Dependencies)
-		
-		for _, command := range rule.Commands {
-			if err := b.executeCommandWithContext(command, autoVars); err != nil {
-				return fmt.Errorf("command failed: %s", err)
-			}
-		}
-	}
-
-	// Mark as no longer building and as built
-	b.building[target] = false
-	b.built[target] = true
-	return nil
+	err := b.run(ctx, order)
+	b.removeIntermediates(order)
+	return err
 }
 
 // IsBuilt returns true if the target has been successfully built in this session.
@@ -108,29 +299,41 @@ func (b *Builder) IsBuilt(target string) bool {
 func (b *Builder) Reset() {
 	b.built = make(map[string]bool)
 	b.building = make(map[string]bool)
+	b.implicit = make(map[string]*types.Rule)
+	b.intermediate = make(map[string]bool)
+	b.mentioned = nil
+	b.mtimes = make(map[string]time.Time)
 }
 
 // needsRebuild determines if a target needs to be rebuilt based on dependency timestamps.
 // A target needs rebuilding if:
+//   - The Builder always makes targets
 //   - The target file doesn't exist
 //   - Any dependency is newer than the target
+//
+// Files given to WithOldFiles never need rebuilding.
 func (b *Builder) needsRebuild(target string, dependencies []string) bool {
-	targetStat, err := os.Stat(target)
-	if err != nil {
-		// Target doesn't exist, needs rebuild
+	if b.oldFiles[target] {
+		return false
+	}
+	if b.alwaysMake {
 		return true
 	}
 
-	targetTime := targetStat.ModTime()
+	targetTime, exists := b.modTime(target)
+	if !exists {
+		// Target doesn't exist, needs rebuild
+		return true
+	}
 
 	// Check if any dependency is newer than the target
 	for _, dep := range dependencies {
-		depStat, err := os.Stat(dep)
-		if err != nil {
+		depTime, exists := b.modTime(dep)
+		if !exists {
 			// Dependency doesn't exist as file, skip timestamp check
 			continue
 		}
-		if depStat.ModTime().After(targetTime) {
+		if depTime.After(targetTime) {
 			return true
 		}
 	}
@@ -138,37 +341,89 @@ func (b *Builder) needsRebuild(target string, dependencies []string) bool {
 	return false
 }
 
+// modTime returns the modification time of a file and whether it exists.
+// Files given to WithWhatIf count as modified now, and those given to
+// WithOldFiles as existing but older than any other. Targets remade by a
+// dry run count as modified when they were remade.
+func (b *Builder) modTime(name string) (time.Time, bool) {
+	if b.oldFiles[name] {
+		return time.Time{}, true
+	}
+	if b.newFiles[name] {
+		return time.Now(), true
+	}
+	if mtime, ok := b.mtimes[name]; ok {
+		return mtime, true
+	}
+	info, err := os.Stat(b.makefile.Path(name))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// touchFile sets the modification time of a target to now, creating it if
+// it does not exist.
+func (b *Builder) touchFile(target string) error {
+	path := b.makefile.Path(target)
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 // fileExists checks if a file exists on the filesystem.
 func (b *Builder) fileExists(filename string) bool {
-	_, err := os.Stat(filename)
+	_, err := os.Stat(b.makefile.Path(filename))
 	return err == nil
 }
 
-// executeCommand executes a shell command and prints it for visibility.
-func (b *Builder) executeCommand(command string) error {
-	fmt.Printf("\t%s\n", command)
-	cmd := exec.Command("sh", "-c", command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// executeCommand executes line with shell, the shell and its flags, and
+// the environment env. A command that exits with a non-zero status returns
+// an *ExitError.
+func (b *Builder) executeCommand(ctx context.Context, shell []string, line recipeLine, env []string) error {
+	cmd := &Command{
+		Argv:   append(append([]string(nil), shell...), line.command),
+		Dir:    b.makefile.Dir,
+		Env:    env,
+		Stdout: b.stdout,
+		Stderr: b.stderr,
+	}
+	if line.always {
+		cmd.ExtraFiles = b.jobserver.files()
+	}
+	status, err := b.executor.Run(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return &ExitError{Status: status}
+	}
+	return nil
 }
 
-// executeCommandWithContext executes a shell command with automatic variable expansion.
-func (b *Builder) executeCommandWithContext(command string, autoVars *types.AutomaticVariables) error {
-	// Expand automatic variables in the command
-	expandedCommand := b.makefile.ExpandVariablesWithContext(command, autoVars)
-	fmt.Printf("\t%s\n", expandedCommand)
-	cmd := exec.Command("sh", "-c", expandedCommand)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+// createAutomaticVariables creates automatic variables context for a rule.
+func (b *Builder) createAutomaticVariables(rule *types.Rule) *types.AutomaticVariables {
+	target := rule.Target
+	dependencies := rule.Dependencies
 
-// createAutomaticVariables creates automatic variables context for a target.
-func (b *Builder) createAutomaticVariables(target string, dependencies []string) *types.AutomaticVariables {
 	autoVars := &types.AutomaticVariables{
-		Target:      target,
-		AllPrereqs:  dependencies,
+		Target:     target,
+		AllPrereqs: dependencies,
+		OrderOnly:  rule.OrderOnly,
+	}
+	
+	// An archive member target, lib.a(member.o), sets $@ to the archive
+	// and $% to the member
+	if open := strings.IndexByte(target, '('); open > 0 && strings.HasSuffix(target, ")") {
+		autoVars.Target = target[:open]
+		autoVars.Member = target[open+1 : len(target)-1]
 	}
 	
 	// Set first prerequisite
@@ -179,27 +434,43 @@ func (b *Builder) createAutomaticVariables(target string, dependencies []string)
 	// Determine newer prerequisites ($?)
 	autoVars.NewerPrereqs = b.getNewerPrerequisites(target, dependencies)
 	
+	// The stem ($*) of a pattern rule is the part matched by '%'; that of
+	// an explicit rule is the target name without a recognised suffix
+	if rule.Stem != "" {
+		autoVars.Stem = rule.Stem
+		return autoVars
+	}
+	name := target
+	if autoVars.Member != "" {
+		name = autoVars.Member
+	}
+	for _, suffix := range types.DefaultSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			autoVars.Stem = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	
 	return autoVars
 }
 
 // getNewerPrerequisites returns prerequisites that are newer than the target.
 func (b *Builder) getNewerPrerequisites(target string, dependencies []string) []string {
-	targetStat, err := os.Stat(target)
-	if err != nil {
+	targetTime, exists := b.modTime(target)
+	if !exists {
 		// If target doesn't exist, all dependencies are "newer"
 		return dependencies
 	}
 	
-	targetTime := targetStat.ModTime()
 	var newerDeps []string
 	
 	for _, dep := range dependencies {
-		depStat, err := os.Stat(dep)
-		if err != nil {
+		depTime, exists := b.modTime(dep)
+		if !exists {
 			// If dependency doesn't exist as file, skip it
 			continue
 		}
-		if depStat.ModTime().After(targetTime) {
+		if depTime.After(targetTime) {
 			newerDeps = append(newerDeps, dep)
 		}
 	}
@@ -1,7 +1,11 @@
 package builder
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -174,4 +178,611 @@ func TestBuilderReset(t *testing.T) {
 	if builder.IsBuilt("test") {
 		t.Error("Target should not be marked as built after reset")
 	}
-}
\ No newline at end of file
+}
+
+func TestBuilderAutomaticVariables(t *testing.T) {
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"out/app.o": {
+				Target:       "out/app.o",
+				Dependencies: []string{"app.c", "app.h", "app.c"},
+				OrderOnly:    []string{"out"},
+				Commands:     []string{"echo '$@|$<|$^|$+|$||$*|$(@D)|$(@F)' > auto.txt"},
+			},
+			"out": {
+				Target:   "out",
+				Commands: []string{"mkdir -p out"},
+			},
+		},
+	}
+
+	tmpdir := t.TempDir()
+	oldwd, _ := os.Getwd()
+	defer os.Chdir(oldwd)
+	os.Chdir(tmpdir)
+
+	os.WriteFile("app.c", []byte(""), 0644)
+	os.WriteFile("app.h", []byte(""), 0644)
+
+	builder := NewBuilder(makefile)
+	if err := builder.Build("out/app.o"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	data, err := os.ReadFile("auto.txt")
+	if err != nil {
+		t.Fatalf("Recipe did not run: %v", err)
+	}
+	expected := "out/app.o|app.c|app.c app.h|app.c app.h app.c|out|out/app|out|app.o\n"
+	if string(data) != expected {
+		t.Errorf("Automatic variables expanded to %q, want %q", data, expected)
+	}
+}
+
+func TestBuilderImplicitRules(t *testing.T) {
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"app": {
+				Target:       "app",
+				Dependencies: []string{"main.o", "parse.o"},
+				Commands:     []string{"echo 'link $^' >> log.txt", "touch $@"},
+			},
+			"main.o": {
+				Target:       "main.o",
+				Dependencies: []string{"config.h"},
+			},
+		},
+		PatternRules: []*types.Rule{
+			{Target: "%.o", Dependencies: []string{"%.c"}, Commands: []string{"echo 'cc $< $* $^' >> log.txt", "touch $@"}},
+		},
+		BuiltinRules: []*types.Rule{
+			{Target: "%.c", Dependencies: []string{"%.y"}, Commands: []string{"echo 'yacc $<' >> log.txt", "touch $@"}},
+			{Target: "%", Dependencies: []string{"%.c"}, Commands: []string{"echo 'match-anything $@' >> log.txt"}},
+		},
+	}
+
+	tmpdir := t.TempDir()
+	oldwd, _ := os.Getwd()
+	defer os.Chdir(oldwd)
+	os.Chdir(tmpdir)
+
+	os.WriteFile("main.c", []byte(""), 0644)
+	os.WriteFile("config.h", []byte(""), 0644)
+	os.WriteFile("parse.y", []byte(""), 0644)
+
+	builder := NewBuilder(makefile)
+	if err := builder.Build("app"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	data, err := os.ReadFile("log.txt")
+	if err != nil {
+		t.Fatalf("Recipes did not run: %v", err)
+	}
+	// main.o keeps its explicit prerequisite, and parse.o is made through
+	// the intermediate parse.c
+	expected := "cc main.c main main.c config.h\nyacc parse.y\ncc parse.c parse parse.c\nlink main.o parse.o\n"
+	if string(data) != expected {
+		t.Errorf("Recipes ran as %q, want %q", data, expected)
+	}
+
+	// The intermediate parse.c was deleted after the build
+	if _, err := os.Stat("parse.c"); err == nil {
+		t.Error("Expected the intermediate parse.c to be deleted")
+	}
+
+	if err := builder.Build("missing.o"); err == nil {
+		t.Error("Expected an error for a target without a usable rule")
+	}
+}
+
+func TestBuilderImplicitRuleSearch(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.BuiltinRules = []*types.Rule{
+		{Target: "%", Dependencies: []string{"%.o"}, Commands: []string{"echo 'link $<' >> log.txt", "touch $@"}},
+		{Target: "%.o", Dependencies: []string{"%.c"}, Commands: []string{"echo 'compile $<' >> log.txt", "touch $@"}},
+		{Target: "%", Dependencies: []string{"%.c"}, Commands: []string{"echo 'cc $<' >> log.txt", "touch $@"}},
+	}
+	os.WriteFile(makefile.Path("hello.c"), nil, 0644)
+
+	// A rule whose prerequisites exist wins over an earlier one that needs
+	// a chain through hello.o
+	var output strings.Builder
+	if err := NewBuilder(makefile, WithOutput(&output, &output)).Build("hello"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if data, _ := os.ReadFile(makefile.Path("log.txt")); string(data) != "cc hello.c\n" {
+		t.Errorf("Recipes ran as %q, want %q", data, "cc hello.c\n")
+	}
+
+	// Without it the chain is used, and the intermediate hello.o is then
+	// deleted unless it is precious
+	makefile.BuiltinRules = makefile.BuiltinRules[:2]
+	for _, precious := range []bool{false, true} {
+		if precious {
+			makefile.Rules[".PRECIOUS"] = &types.Rule{Target: ".PRECIOUS", Dependencies: []string{"%.o"}}
+		}
+		os.Remove(makefile.Path("hello"))
+		os.Remove(makefile.Path("log.txt"))
+		output.Reset()
+		if err := NewBuilder(makefile, WithOutput(&output, &output)).Build("hello"); err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if data, _ := os.ReadFile(makefile.Path("log.txt")); string(data) != "compile hello.c\nlink hello.o\n" {
+			t.Errorf("Recipes ran as %q with the chain", data)
+		}
+		_, err := os.Stat(makefile.Path("hello.o"))
+		if (err == nil) != precious || strings.Contains(output.String(), "rm hello.o\n") == precious {
+			t.Errorf("Expected hello.o to be kept: %v, got %q", precious, output.String())
+		}
+	}
+}
+
+func TestBuilderTargetVariables(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Rules = map[string]*types.Rule{
+		"release": {Target: "release", Dependencies: []string{"app"}},
+		"debug":   {Target: "debug", Dependencies: []string{"app"}},
+		"app": {
+			Target:   "app",
+			Commands: []string{"echo \"$(FLAGS) $$MODE\" > $(OUT)"},
+		},
+	}
+	makefile.Assign("FLAGS", types.AssignRecursive, "-O2")
+	makefile.Assign("OUT", types.AssignRecursive, "app.txt")
+	makefile.AddTargetVariable(&types.TargetVariable{Target: "debug", Name: "FLAGS", Op: types.AssignAppend, Value: "-g", Origin: types.OriginFile})
+	makefile.AddTargetVariable(&types.TargetVariable{Target: "debug", Name: "MODE", Op: types.AssignRecursive, Value: "debug", Origin: types.OriginFile, Export: true})
+
+	tmpdir := t.TempDir()
+	oldwd, _ := os.Getwd()
+	defer os.Chdir(oldwd)
+	os.Chdir(tmpdir)
+
+	// app inherits the variables of the target it is built for
+	for _, test := range []struct{ target, want string }{
+		{"release", "-O2 \n"},
+		{"debug", "-O2 -g debug\n"},
+	} {
+		builder := NewBuilder(makefile)
+		if err := builder.Build(test.target); err != nil {
+			t.Fatalf("Build(%s) failed: %v", test.target, err)
+		}
+		data, _ := os.ReadFile("app.txt")
+		if string(data) != test.want {
+			t.Errorf("Build(%s) wrote %q, want %q", test.target, data, test.want)
+		}
+		os.Remove("app.txt")
+	}
+}
+
+func TestBuilderParallel(t *testing.T) {
+	// Each object waits for the others to start, so the build only
+	// succeeds if their recipes run at the same time
+	wait := "touch $@.start; for i in $$(seq 200); do [ -f a.o.start ] && [ -f b.o.start ] && [ -f c.o.start ] && exit 0; sleep 0.01; done; exit 1"
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"app": {Target: "app", Dependencies: []string{"a.o", "b.o", "c.o"}, Commands: []string{"touch $@"}},
+			"a.o": {Target: "a.o", Commands: []string{wait, "touch $@"}},
+			"b.o": {Target: "b.o", Commands: []string{wait, "touch $@"}},
+			"c.o": {Target: "c.o", Commands: []string{wait, "touch $@"}},
+		},
+	}
+
+	for _, jobs := range []int{3, 0} {
+		tmpdir := t.TempDir()
+		makefile.Dir = tmpdir
+
+		builder := NewBuilder(makefile, WithJobs(jobs))
+		if err := builder.Build("app"); err != nil {
+			t.Fatalf("Build with %d jobs failed: %v", jobs, err)
+		}
+		for _, target := range []string{"app", "a.o", "b.o", "c.o"} {
+			if !builder.IsBuilt(target) {
+				t.Errorf("Target %s should be marked as built with %d jobs", target, jobs)
+			}
+		}
+	}
+}
+
+func TestBuilderParallelOrder(t *testing.T) {
+	// With one job, recipes run in the order of a serial build; with more,
+	// a target still waits for all of its prerequisites
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"all":  {Target: "all", Dependencies: []string{"lib", "app"}, Commands: []string{"echo all >> log"}},
+			"app":  {Target: "app", Dependencies: []string{"lib"}, Commands: []string{"sleep 0.1; echo app >> log"}},
+			"lib":  {Target: "lib", Dependencies: []string{"gen"}, Commands: []string{"echo lib >> log"}},
+			"gen":  {Target: "gen", OrderOnly: []string{"dirs"}, Commands: []string{"echo gen >> log"}},
+			"dirs": {Target: "dirs", Commands: []string{"echo dirs >> log"}},
+		},
+	}
+
+	for _, jobs := range []int{1, 4} {
+		makefile.Dir = t.TempDir()
+		if err := NewBuilder(makefile, WithJobs(jobs)).Build("all"); err != nil {
+			t.Fatalf("Build with %d jobs failed: %v", jobs, err)
+		}
+		data, _ := os.ReadFile(makefile.Path("log"))
+		if got, want := string(data), "dirs\ngen\nlib\napp\nall\n"; got != want {
+			t.Errorf("Build with %d jobs ran %q, want %q", jobs, got, want)
+		}
+	}
+}
+
+func TestBuilderParallelFailure(t *testing.T) {
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"all":   {Target: "all", Dependencies: []string{"bad", "slow", "later"}, Commands: []string{"touch $@"}},
+			"bad":   {Target: "bad", Commands: []string{"exit 1"}},
+			"slow":  {Target: "slow", Commands: []string{"sleep 0.2; touch $@"}},
+			"later": {Target: "later", Commands: []string{"touch $@"}},
+		},
+	}
+	makefile.Dir = t.TempDir()
+
+	// bad and slow start together; once bad fails, later is not started
+	// but slow is allowed to finish
+	builder := NewBuilder(makefile, WithJobs(2))
+	err := builder.Build("all")
+	if err == nil || !strings.Contains(err.Error(), "command failed") {
+		t.Fatalf("Expected command failure, got %v", err)
+	}
+	for target, want := range map[string]bool{"slow": true, "later": false, "all": false} {
+		if _, err := os.Stat(makefile.Path(target)); (err == nil) != want {
+			t.Errorf("Expected %s to exist: %v", target, want)
+		}
+	}
+	if builder.IsBuilt("bad") {
+		t.Error("Failed target should not be marked as built")
+	}
+}
+
+func TestBuilderKeepGoing(t *testing.T) {
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"all":  {Target: "all", Dependencies: []string{"app", "lib", "docs"}, Commands: []string{"touch $@"}},
+			"app":  {Target: "app", Dependencies: []string{"a.o", "b.o"}, Commands: []string{"touch $@"}},
+			"a.o":  {Target: "a.o", Commands: []string{"exit 3"}},
+			"b.o":  {Target: "b.o", Commands: []string{"touch $@"}},
+			"lib":  {Target: "lib", Dependencies: []string{"c.o"}, Commands: []string{"touch $@"}},
+			"c.o":  {Target: "c.o", Commands: []string{"touch $@"}},
+			"docs": {Target: "docs", Dependencies: []string{"guide"}, Commands: []string{"touch $@"}},
+		},
+	}
+
+	for _, jobs := range []int{1, 4} {
+		makefile.Dir = t.TempDir()
+		builder := NewBuilder(makefile, WithKeepGoing(), WithJobs(jobs))
+		err := builder.Build("all")
+
+		var buildErr *BuildError
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("Expected a *BuildError with %d jobs, got %v", jobs, err)
+		}
+		// The order of the failures depends on the scheduling
+		var failed []string
+		for _, f := range buildErr.Failed {
+			failed = append(failed, fmt.Sprintf("%s:%d", f.Target, f.ExitStatus))
+		}
+		sort.Strings(failed)
+		if got, want := strings.Join(failed, " "), "a.o:3 guide:-1"; got != want {
+			t.Errorf("Failed = %s, want %s", got, want)
+		}
+		notRemade := append([]string(nil), buildErr.NotRemade...)
+		sort.Strings(notRemade)
+		if got, want := strings.Join(notRemade, " "), "all app docs"; got != want {
+			t.Errorf("NotRemade = %s, want %s", got, want)
+		}
+
+		// Everything that does not depend on a failure is built
+		for target, want := range map[string]bool{"b.o": true, "c.o": true, "lib": true, "app": false, "all": false} {
+			if builder.IsBuilt(target) != want {
+				t.Errorf("IsBuilt(%s) = %v with %d jobs", target, !want, jobs)
+			}
+		}
+	}
+}
+
+func TestBuilderRecipePrefixes(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Assign("Q", types.AssignRecursive, "@")
+	makefile.Rules["all"] = &types.Rule{
+		Target:     "all",
+		Commands:   []string{"-exit 4", "@-+ exit 5", "echo after", "$(Q)touch $@"},
+		CommandPos: []types.Position{{File: "Makefile", Line: 2}, {File: "Makefile", Line: 3}, {File: "Makefile", Line: 4}, {File: "Makefile", Line: 5}},
+	}
+
+	// The warnings keep their place among the output of the recipe
+	var output strings.Builder
+	if err := NewBuilder(makefile, WithOutput(&output, &output)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if _, err := os.Stat(makefile.Path("all")); err != nil {
+		t.Errorf("Expected the line after ignored errors to run: %v", err)
+	}
+	want := "Building target: all\n\texit 4\nMakefile:2: [all] Error 4 (ignored)\nMakefile:3: [all] Error 5 (ignored)\n\techo after\nafter\n"
+	if output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestBuilderCannedRecipe(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Assign("steps", types.AssignRecursive, "@echo one\n@echo two\n-exit 3\n@echo three")
+	makefile.Rules["all"] = &types.Rule{
+		Target:     "all",
+		Commands:   []string{"$(steps)"},
+		CommandPos: []types.Position{{File: "Makefile", Line: 7}},
+	}
+
+	// Each line of the expansion has prefixes of its own
+	var output strings.Builder
+	if err := NewBuilder(makefile, WithOutput(&output, &output)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := "Building target: all\none\ntwo\n\texit 3\nMakefile:7: [all] Error 3 (ignored)\nthree\n"
+	if output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+
+	// A dry run prints the lines without their prefixes
+	output.Reset()
+	if err := NewBuilder(makefile, WithDryRun(), WithOutput(&output, &output)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want = "\techo one\n\techo two\n\texit 3\n\techo three\n"
+	if output.String() != want {
+		t.Errorf("dry run output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestBuilderDryRun(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Assign("MAKE", types.AssignRecursive, "touch")
+	makefile.Rules = map[string]*types.Rule{
+		"all": {Target: "all", Dependencies: []string{"app", "sub"}, Commands: []string{"touch $@"}},
+		"app": {Target: "app", Dependencies: []string{"gen"}, Commands: []string{"touch $@", "+@touch $@.shown"}},
+		"gen": {Target: "gen", Commands: []string{"touch $@"}},
+		"sub": {Target: "sub", Commands: []string{"$(MAKE) sub.made"}},
+	}
+	// app exists, but gen would be remade, so app would be remade after it
+	os.WriteFile(makefile.Path("app"), nil, 0644)
+
+	var output strings.Builder
+	builder := NewBuilder(makefile, WithDryRun(), WithOutput(&output, &output))
+	if err := builder.Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	// Every command is printed, silent ones included, even those that run,
+	// and nothing else
+	if !strings.Contains(output.String(), "\ttouch app.shown\n") {
+		t.Errorf("Expected the silent '+' line to be printed, got %q", output.String())
+	}
+	if strings.Contains(output.String(), "Building target") {
+		t.Errorf("Expected only commands in a dry run, got %q", output.String())
+	}
+	for name, want := range map[string]bool{"all": false, "gen": false, "app.shown": true, "sub.made": true} {
+		if _, err := os.Stat(makefile.Path(name)); (err == nil) != want {
+			t.Errorf("Expected %s to exist after a dry run: %v", name, want)
+		}
+	}
+}
+
+func TestBuilderTouchQuestionAlwaysMake(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules = map[string]*types.Rule{
+		"app":   {Target: "app", Dependencies: []string{"app.o"}, Commands: []string{"echo linked > $@", "+touch touched.always"}},
+		"app.o": {Target: "app.o", Commands: []string{"echo compiled > $@"}},
+	}
+	question := func() error {
+		return NewBuilder(makefile, WithQuestion()).Build("app")
+	}
+
+	// Nothing is built yet, and asking runs nothing
+	if err := question(); !errors.Is(err, ErrNotUpToDate) {
+		t.Fatalf("Expected ErrNotUpToDate, got %v", err)
+	}
+	if _, err := os.Stat(makefile.Path("app.o")); err == nil {
+		t.Fatal("Question mode should not run recipes")
+	}
+
+	// Touching creates empty targets, running only the '+' line
+	if err := NewBuilder(makefile, WithTouch()).Build("app"); err != nil {
+		t.Fatalf("Build with touch failed: %v", err)
+	}
+	for name, want := range map[string]string{"app": "", "app.o": "", "touched.always": ""} {
+		if data, err := os.ReadFile(makefile.Path(name)); err != nil || string(data) != want {
+			t.Errorf("After touch %s = %q, %v", name, data, err)
+		}
+	}
+	if err := question(); err != nil {
+		t.Errorf("Expected touched targets to be up to date, got %v", err)
+	}
+
+	// Always-make remakes them anyway
+	if err := NewBuilder(makefile, WithAlwaysMake()).Build("app"); err != nil {
+		t.Fatalf("Build with always-make failed: %v", err)
+	}
+	if data, _ := os.ReadFile(makefile.Path("app")); string(data) != "linked\n" {
+		t.Errorf("Expected always-make to relink app, got %q", data)
+	}
+	if err := NewBuilder(makefile, WithQuestion(), WithAlwaysMake()).Build("app"); !errors.Is(err, ErrNotUpToDate) {
+		t.Errorf("Expected ErrNotUpToDate with always-make, got %v", err)
+	}
+
+	// A goal without a recipe, such as a phony all, has nothing to run
+	// and nothing to print
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"app"}}
+	var output strings.Builder
+	if err := NewBuilder(makefile, WithQuestion(), WithOutput(&output, &output)).Build("all"); err != nil {
+		t.Errorf("Expected all to be up to date, got %v", err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("Expected no output in question mode, got %q", output.String())
+	}
+
+	// A phony target is never touched
+	makefile.Rules["clean"] = &types.Rule{Target: "clean", Commands: []string{"rm -f app app.o"}}
+	makefile.Rules[".PHONY"] = &types.Rule{Target: ".PHONY", Dependencies: []string{"clean"}}
+	output.Reset()
+	if err := NewBuilder(makefile, WithTouch(), WithOutput(&output, &output)).Build("clean"); err != nil {
+		t.Fatalf("Build with touch failed: %v", err)
+	}
+	if _, err := os.Stat(makefile.Path("clean")); err == nil || strings.Contains(output.String(), "touch clean") {
+		t.Errorf("Expected the phony clean not to be touched, got %q", output.String())
+	}
+}
+
+func TestBuilderWhatIfOldFiles(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules = map[string]*types.Rule{
+		"app":    {Target: "app", Dependencies: []string{"main.o", "util.o"}, Commands: []string{"echo $? > $@"}},
+		"main.o": {Target: "main.o", Dependencies: []string{"main.c", "foo.h"}, Commands: []string{"touch $@"}},
+		"util.o": {Target: "util.o", Dependencies: []string{"util.c"}, Commands: []string{"touch $@"}},
+	}
+
+	// Everything is up to date
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"foo.h", "main.c", "util.c", "main.o", "util.o", "app"} {
+		os.WriteFile(makefile.Path(name), nil, 0644)
+		os.Chtimes(makefile.Path(name), past, past)
+		past = past.Add(time.Minute)
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		want    string
+	}{
+		{"what-if", []Option{WithWhatIf("foo.h")}, "main.o\n"},
+		{"old file", []Option{WithWhatIf("util.c", "foo.h"), WithOldFiles("main.o")}, "util.o\n"},
+		{"old file only", []Option{WithOldFiles("foo.h"), WithAlwaysMake()}, "main.o util.o\n"},
+	}
+	for _, tt := range tests {
+		os.WriteFile(makefile.Path("app"), nil, 0644)
+		os.Chtimes(makefile.Path("app"), past, past)
+
+		if err := NewBuilder(makefile, tt.options...).Build("app"); err != nil {
+			t.Fatalf("%s: Build failed: %v", tt.name, err)
+		}
+		if data, _ := os.ReadFile(makefile.Path("app")); string(data) != tt.want {
+			t.Errorf("%s: app was linked from %q, want %q", tt.name, data, tt.want)
+		}
+	}
+
+	// An old file is not remade even when a prerequisite is newer
+	if err := NewBuilder(makefile, WithQuestion(), WithWhatIf("foo.h"), WithOldFiles("main.o")).Build("main.o"); err != nil {
+		t.Errorf("Expected main.o to be up to date, got %v", err)
+	}
+
+	// Nor are its prerequisites, even stale ones that have a recipe
+	makefile.Rules["foo.h"] = &types.Rule{Target: "foo.h", Dependencies: []string{"foo.in"}, Commands: []string{"touch $@"}}
+	os.WriteFile(makefile.Path("foo.in"), nil, 0644)
+	before, _ := os.Stat(makefile.Path("foo.h"))
+	var output strings.Builder
+	if err := NewBuilder(makefile, WithOldFiles("main.o"), WithOutput(&output, &output)).Build("main.o"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if after, _ := os.Stat(makefile.Path("foo.h")); !after.ModTime().Equal(before.ModTime()) || output.Len() != 0 {
+		t.Errorf("Expected foo.h not to be remade for an old main.o, got %q", output.String())
+	}
+}
+
+func TestBuilderBuildContext(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"first", "slow"}}
+	makefile.Rules["first"] = &types.Rule{Target: "first", Commands: []string{"echo run >> first.log; touch $@"}}
+	makefile.Rules["slow"] = &types.Rule{
+		Target: "slow",
+		// The background process leaves the recipe's shell behind
+		Commands: []string{"(sleep 1; touch survived) & touch $@ started; sleep 30"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, err := os.Stat(makefile.Path("started")); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	builder := NewBuilder(makefile)
+	start := time.Now()
+	err := builder.BuildContext(ctx, "all")
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a *CanceledError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("The canceled build took %v", elapsed)
+	}
+	if !builder.IsBuilt("first") || builder.IsBuilt("slow") || builder.IsBuilt("all") {
+		t.Error("Expected only first to be built")
+	}
+	if _, err := os.Stat(makefile.Path("slow")); err == nil {
+		t.Error("Expected the interrupted target to be deleted")
+	}
+
+	// The whole process group of the recipe was stopped
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(makefile.Path("survived")); err == nil {
+		t.Error("Expected the background process of the recipe to be killed")
+	}
+
+	// The builder remains usable, and does not remake what it built
+	if err := builder.BuildContext(context.Background(), "first"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if data, _ := os.ReadFile(makefile.Path("first.log")); string(data) != "run\n" {
+		t.Errorf("first.log = %q, want first built once", data)
+	}
+}
+
+func TestBuilderIgnoredErrorsParallel(t *testing.T) {
+	// The warnings of recipes running at once go to a writer that is not
+	// safe for concurrent use; run with -race to check they are serialized
+	var stdout, stderr strings.Builder
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"a", "b", "c", "d"}}
+	for _, target := range []string{"a", "b", "c", "d"} {
+		makefile.Rules[target] = &types.Rule{Target: target, Commands: []string{"-exit 1", "-exit 2"}}
+	}
+
+	if err := NewBuilder(makefile, WithJobs(4), WithOutput(&stdout, &stderr)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if got := strings.Count(stderr.String(), "(ignored)"); got != 8 {
+		t.Errorf("Got %d warnings, want 8:\n%s", got, stderr.String())
+	}
+}
+
+func TestBuilderOutput(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"lib"}, Commands: []string{"echo linked; echo oops >&2"}}
+	makefile.Rules["lib"] = &types.Rule{Target: "lib", Commands: []string{"@echo compiled"}}
+
+	var stdout, stderr strings.Builder
+	if err := NewBuilder(makefile, WithOutput(&stdout, &stderr)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := "Building target: lib\ncompiled\nBuilding target: all\n\techo linked; echo oops >&2\nlinked\n"
+	if stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if stderr.String() != "oops\n" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "oops\n")
+	}
+}
@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TargetError reports a target that failed in a keep-going build.
+type TargetError struct {
+	// Target is the name of the failed target
+	Target string
+
+	// ExitStatus is the exit status of the command that failed, or -1 if
+	// the target failed without a command failing, such as when it has
+	// no rule
+	ExitStatus int
+
+	// Err is the error the target failed with
+	Err error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("target '%s' failed: %v", e.Target, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// BuildError is returned by a keep-going build in which targets failed.
+// Its message has a line for each failed target.
+type BuildError struct {
+	// Failed lists the failed targets in the order they failed
+	Failed []*TargetError
+
+	// NotRemade lists the targets that were not remade because one of
+	// their prerequisites failed
+	NotRemade []string
+}
+
+func (e *BuildError) Error() string {
+	var lines []string
+	for _, failed := range e.Failed {
+		lines = append(lines, failed.Error())
+	}
+	if len(e.NotRemade) > 0 {
+		lines = append(lines, fmt.Sprintf("not remade because of errors: %s", strings.Join(e.NotRemade, " ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the errors of the failed targets.
+func (e *BuildError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, failed := range e.Failed {
+		errs[i] = failed
+	}
+	return errs
+}
+
+// CanceledError is returned by a build that was stopped because its
+// context was canceled. Err is the context's error, so that errors.Is
+// matches context.Canceled or context.DeadlineExceeded.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("build canceled: %v", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ExitError reports a command that exited with a non-zero status.
+type ExitError struct {
+	Status int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// exitStatus returns the exit status of the command that caused err, or
+// -1 if err was not caused by a command exiting.
+func exitStatus(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Status
+	}
+	return -1
+}
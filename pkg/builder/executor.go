@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Command is a command for an Executor to run, such as a line of a recipe
+// passed to the shell.
+type Command struct {
+	// Argv is the program to run followed by its arguments
+	Argv []string
+
+	// Dir is the directory to run the command in; "" means the current one
+	Dir string
+
+	// Env is the environment of the command
+	Env []string
+
+	// Stdin, Stdout and Stderr are the standard streams of the command.
+	// A nil stream is connected to the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// ExtraFiles are inherited by the command as file descriptors 3 and
+	// up, as with exec.Cmd. The jobserver is passed to recipes this way.
+	ExtraFiles []*os.File
+}
+
+// Executor runs the commands of recipes. Implementations may run them in
+// another way than as local processes, capture their output, or record
+// them in tests.
+type Executor interface {
+	// Run runs cmd and returns its exit status. A command that runs and
+	// exits with a non-zero status is not an error; err reports a
+	// command that could not be run at all. When ctx is canceled, Run
+	// stops the command and everything it started, and returns ctx.Err().
+	Run(ctx context.Context, cmd *Command) (status int, err error)
+}
+
+// killDelay is how long a canceled command has to exit after SIGTERM
+// before it is killed.
+var killDelay = 2 * time.Second
+
+// ProcessExecutor is the default Executor. It runs each command as a
+// local process with os/exec.
+//
+// On Unix a command run with a context that can be canceled leads a process
+// group of its own. Canceling the command stops the whole group, so that
+// the processes a recipe leaves behind, such as those of a pipeline or a
+// sub-make, go with it. Other commands stay in the process group of the
+// caller, so that an interrupt from the terminal reaches them as well.
+type ProcessExecutor struct{}
+
+// Run runs cmd as a local process.
+func (ProcessExecutor) Run(ctx context.Context, cmd *Command) (int, error) {
+	if len(cmd.Argv) == 0 {
+		return 0, errors.New("empty command")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c := exec.Command(cmd.Argv[0], cmd.Argv[1:]...)
+	c.Dir = cmd.Dir
+	c.Env = cmd.Env
+	c.Stdin = cmd.Stdin
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	c.ExtraFiles = cmd.ExtraFiles
+	if ctx.Done() != nil {
+		setProcessGroup(c)
+	}
+	if err := c.Start(); err != nil {
+		return 0, err
+	}
+
+	// The process group is stopped from another goroutine, so that the
+	// leader is reaped as soon as it exits
+	finished := make(chan struct{})
+	stopped := make(chan struct{})
+	canceled := false
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			canceled = true
+			stopProcessGroup(c.Process)
+		case <-finished:
+		}
+	}()
+	err := c.Wait()
+	close(finished)
+	<-stopped
+	// A command that exited on its own before it could be stopped is not
+	// canceled, even if ctx was canceled in the meantime
+	if err != nil && canceled {
+		return 0, ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
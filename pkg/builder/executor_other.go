@@ -0,0 +1,18 @@
+//go:build !unix
+
+package builder
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on this platform, which has no process
+// groups.
+func setProcessGroup(c *exec.Cmd) {}
+
+// stopProcessGroup kills p. Without process groups, the processes it
+// started are not reached.
+func stopProcessGroup(p *os.Process) {
+	p.Kill()
+}
@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// recordingExecutor records the commands it is given instead of running
+// them, and exits with the status statuses holds for a command, if any.
+type recordingExecutor struct {
+	mu       sync.Mutex
+	commands []*Command
+	statuses map[string]int
+}
+
+func (e *recordingExecutor) Run(ctx context.Context, cmd *Command) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands = append(e.commands, cmd)
+	return e.statuses[cmd.Argv[len(cmd.Argv)-1]], nil
+}
+
+func TestBuilderExecutor(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"lib"}, Commands: []string{"link $@"}}
+	makefile.Rules["lib"] = &types.Rule{Target: "lib", Commands: []string{"-compile $@", "archive $@"}}
+
+	tests := []struct {
+		name     string
+		statuses map[string]int
+		commands []string
+		status   int
+	}{
+		{"success", nil, []string{"compile lib", "archive lib", "link all"}, 0},
+		{"ignored failure", map[string]int{"compile lib": 1}, []string{"compile lib", "archive lib", "link all"}, 0},
+		{"failure", map[string]int{"archive lib": 3}, []string{"compile lib", "archive lib"}, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output strings.Builder
+			executor := &recordingExecutor{statuses: test.statuses}
+			err := NewBuilder(makefile, WithExecutor(executor), WithOutput(&output, &output)).Build("all")
+
+			var commands []string
+			for _, cmd := range executor.commands {
+				if cmd.Dir != makefile.Dir || len(cmd.Argv) != 3 || cmd.Argv[0] != types.DefaultShell {
+					t.Errorf("Unexpected command %q in %q", cmd.Argv, cmd.Dir)
+				}
+				commands = append(commands, cmd.Argv[2])
+			}
+			if strings.Join(commands, "; ") != strings.Join(test.commands, "; ") {
+				t.Errorf("Ran %q, want %q", commands, test.commands)
+			}
+
+			var exitErr *ExitError
+			switch {
+			case test.status == 0 && err != nil:
+				t.Errorf("Build failed: %v", err)
+			case test.status != 0 && (!errors.As(err, &exitErr) || exitErr.Status != test.status):
+				t.Errorf("Expected exit status %d, got %v", test.status, err)
+			}
+		})
+	}
+}
+
+func TestBuilderShell(t *testing.T) {
+	// As in GNU make, SHELL is never taken from the environment
+	t.Setenv("SHELL", "/bin/false")
+
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Dependencies: []string{"bash", "python"}, Commands: []string{"true"}}
+	makefile.Rules["bash"] = &types.Rule{Target: "bash", Commands: []string{"false | true"}}
+	makefile.Rules["python"] = &types.Rule{Target: "python", Commands: []string{"print('$@')"}}
+	makefile.AddTargetVariable(&types.TargetVariable{Target: "bash", Name: "SHELL", Op: types.AssignRecursive, Value: "/bin/bash", Origin: types.OriginFile})
+	makefile.AddTargetVariable(&types.TargetVariable{Target: "bash", Name: ".SHELLFLAGS", Op: types.AssignRecursive, Value: "-o pipefail -c", Origin: types.OriginFile})
+	makefile.AddTargetVariable(&types.TargetVariable{Target: "python", Name: "SHELL", Op: types.AssignSimple, Value: "python3", Origin: types.OriginFile})
+
+	executor := &recordingExecutor{}
+	if err := NewBuilder(makefile, WithExecutor(executor)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := map[string]string{
+		"false | true":    "/bin/bash -o pipefail -c",
+		"print('python')": "python3 -c",
+		"true":            "/bin/sh -c",
+	}
+	for _, cmd := range executor.commands {
+		command := cmd.Argv[len(cmd.Argv)-1]
+		if shell := strings.Join(cmd.Argv[:len(cmd.Argv)-1], " "); shell != want[command] {
+			t.Errorf("%q ran with %q, want %q", command, shell, want[command])
+		}
+	}
+
+	// With pipefail, the failure of the first command fails the pipeline
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	var exitErr *ExitError
+	if err := NewBuilder(makefile).Build("bash"); !errors.As(err, &exitErr) {
+		t.Errorf("Expected the bash recipe to fail, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package builder
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup makes the command the leader of a new process group,
+// which then holds every process it starts, unless they leave it.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// stopProcessGroup sends SIGTERM to the process group led by p, so that a
+// sub-make can stop its own recipes, and SIGKILL to whatever is left of the
+// group after killDelay.
+func stopProcessGroup(p *os.Process) {
+	pgid := p.Pid
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	for deadline := time.Now().Add(killDelay); time.Now().Before(deadline); {
+		if syscall.Kill(-pgid, 0) != nil {
+			// The group is empty
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
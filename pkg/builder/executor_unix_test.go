@@ -0,0 +1,39 @@
+//go:build unix
+
+package builder
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestProcessExecutorProcessGroup(t *testing.T) {
+	pgid := func(ctx context.Context) int {
+		var out strings.Builder
+		cmd := &Command{Argv: []string{"/bin/sh", "-c", "ps -o pgid= -p $$"}, Stdout: &out}
+		if status, err := (ProcessExecutor{}).Run(ctx, cmd); err != nil || status != 0 {
+			t.Fatalf("Run failed: %d, %v", status, err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(out.String()))
+		if err != nil {
+			t.Fatalf("Unexpected output %q", out.String())
+		}
+		return n
+	}
+
+	// A command that cannot be canceled stays in the caller's group, so
+	// that an interrupt from the terminal reaches it
+	if got := pgid(context.Background()); got != syscall.Getpgrp() {
+		t.Errorf("Expected the command in process group %d, got %d", syscall.Getpgrp(), got)
+	}
+
+	// One that can be canceled leads a group of its own
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := pgid(ctx); got == syscall.Getpgrp() {
+		t.Error("Expected the command in a process group of its own")
+	}
+}
@@ -0,0 +1,201 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// maxChainLength limits how many implicit rules may be chained to make a
+// prerequisite, as in foo.o from foo.c from foo.y.
+const maxChainLength = 4
+
+// implicitRule finds the implicit rule that builds target, searching the
+// Makefile's pattern rules before the built-in ones. If target also has an
+// explicit rule without a recipe, its prerequisites are added after those of
+// the implicit rule. It returns nil if no implicit rule applies.
+func (b *Builder) implicitRule(target string, explicit *types.Rule) *types.Rule {
+	rule := b.findImplicitRule(target, 0)
+	if rule == nil || explicit == nil {
+		return rule
+	}
+
+	merged := *rule
+	merged.Dependencies = append(append([]string{}, rule.Dependencies...), explicit.Dependencies...)
+	merged.OrderOnly = append(append([]string{}, rule.OrderOnly...), explicit.OrderOnly...)
+	return &merged
+}
+
+// findImplicitRule returns the pattern rule, instantiated for target, that
+// GNU make would pick. Matching rules are tried in order of increasing stem
+// length, first for one whose prerequisites all exist or ought to exist,
+// and only if there is none for one whose prerequisites can themselves be
+// made by a chain of implicit rules. The prerequisites made that way are
+// intermediate files. depth is the position of target in a chain.
+func (b *Builder) findImplicitRule(target string, depth int) *types.Rule {
+	if rule, ok := b.implicit[target]; ok {
+		return rule
+	}
+
+	candidates := b.matchingRules(target, depth > 0)
+	for _, chain := range []bool{false, true} {
+		for _, rule := range candidates {
+			if b.canMake(rule, depth, chain) {
+				b.implicit[target] = rule
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+// matchingRules instantiates every pattern rule whose target pattern matches
+// target, ordered by stem length. Match-anything rules such as "%: %.o" are
+// left out for intermediate files, and for file names that some other
+// pattern rule or a known suffix identifies as a specific kind of file.
+func (b *Builder) matchingRules(target string, intermediate bool) []*types.Rule {
+	rules := append(append([]*types.Rule{}, b.makefile.PatternRules...), b.makefile.BuiltinRules...)
+
+	matchAnything := !intermediate
+	var matches []*types.Rule
+	var anything []*types.Rule
+	for _, pattern := range rules {
+		rule, ok := pattern.Instantiate(target)
+		if !ok || len(rule.Commands) == 0 {
+			continue
+		}
+		if pattern.IsMatchAnything() {
+			anything = append(anything, rule)
+			continue
+		}
+		matchAnything = false
+		matches = append(matches, rule)
+	}
+
+	// The built-in suffixes identify kinds of files too, as GNU make's
+	// dummy suffix rules do
+	if matchAnything && len(b.makefile.BuiltinRules) > 0 {
+		for _, suffix := range types.DefaultSuffixes {
+			if strings.HasSuffix(target, suffix) {
+				matchAnything = false
+				break
+			}
+		}
+	}
+	if matchAnything {
+		matches = append(matches, anything...)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return len(matches[i].Stem) < len(matches[j].Stem)
+	})
+	return matches
+}
+
+// canMake reports whether every prerequisite of an instantiated pattern rule
+// exists or ought to exist, or, if chain is set, can be made by chaining
+// further implicit rules. The prerequisites that are chained are recorded
+// as intermediate once the rule is known to apply.
+func (b *Builder) canMake(rule *types.Rule, depth int, chain bool) bool {
+	var intermediate []string
+	for _, prereqs := range [][]string{rule.Dependencies, rule.OrderOnly} {
+		for _, prereq := range prereqs {
+			if prereq == rule.Target {
+				return false
+			}
+			if b.oughtToExist(prereq) {
+				continue
+			}
+			if !chain || depth+1 >= maxChainLength || b.findImplicitRule(prereq, depth+1) == nil {
+				return false
+			}
+			intermediate = append(intermediate, prereq)
+		}
+	}
+	for _, prereq := range intermediate {
+		b.intermediate[prereq] = true
+	}
+	return true
+}
+
+// oughtToExist reports whether name exists as a file, or is a target or
+// prerequisite mentioned in an explicit rule. Naming a file as a
+// prerequisite of a special target such as .PRECIOUS does not count.
+func (b *Builder) oughtToExist(name string) bool {
+	if b.mentioned == nil {
+		b.mentioned = make(map[string]bool)
+		for target, rule := range b.makefile.Rules {
+			if isSpecialTarget(target) {
+				continue
+			}
+			b.mentioned[target] = true
+			for _, dep := range rule.Dependencies {
+				b.mentioned[dep] = true
+			}
+			for _, dep := range rule.OrderOnly {
+				b.mentioned[dep] = true
+			}
+		}
+	}
+	return b.mentioned[name] || b.fileExists(name)
+}
+
+// isSpecialTarget reports whether target is a special target such as
+// .PHONY, whose name is a '.' followed by capital letters.
+func isSpecialTarget(target string) bool {
+	if len(target) < 2 || target[0] != '.' {
+		return false
+	}
+	for _, r := range target[1:] {
+		if (r < 'A' || r > 'Z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// hasSpecialPrereq reports whether target is a prerequisite of the special
+// target special. For .PRECIOUS, a pattern such as "%.o" matches too.
+func (b *Builder) hasSpecialPrereq(special, target string) bool {
+	rule, ok := b.makefile.Rules[special]
+	if !ok {
+		return false
+	}
+	for _, prereq := range rule.Dependencies {
+		if prereq == target {
+			return true
+		}
+		if special != ".PRECIOUS" || !types.IsPattern(prereq) {
+			continue
+		}
+		if _, ok := (&types.Rule{Target: prereq}).Instantiate(target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeIntermediates deletes the intermediate files of order that the
+// build made, as GNU make does, unless they are precious or secondary.
+// A .SECONDARY target without prerequisites makes every file secondary.
+func (b *Builder) removeIntermediates(order []*node) {
+	if b.dryRun || b.touch {
+		return
+	}
+	if rule, ok := b.makefile.Rules[".SECONDARY"]; ok && len(rule.Dependencies) == 0 {
+		return
+	}
+	for _, n := range order {
+		if !n.intermediate || b.hasSpecialPrereq(".PRECIOUS", n.target) || b.hasSpecialPrereq(".SECONDARY", n.target) {
+			continue
+		}
+		if !b.fileExists(n.target) {
+			continue
+		}
+		fmt.Fprintf(b.stdout, "rm %s\n", n.target)
+		os.Remove(b.makefile.Path(n.target))
+	}
+}
@@ -0,0 +1,193 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Jobserver styles, as chosen with GNU make's --jobserver-style option.
+const (
+	// JobserverFifo shares the tokens through a named pipe, which child
+	// processes open by name
+	JobserverFifo = "fifo"
+
+	// JobserverPipe shares the tokens through an anonymous pipe whose file
+	// descriptors child processes inherit
+	JobserverPipe = "pipe"
+)
+
+// Jobserver is a pool of job tokens shared by a make and the sub-makes and
+// other tools it runs, following GNU make's jobserver protocol. Each make
+// may always run one job; every further job needs a token, a byte read
+// from the jobserver, which is written back when the job finishes.
+// Children find the jobserver through --jobserver-auth in MAKEFLAGS.
+type Jobserver struct {
+	read  *os.File
+	write *os.File
+
+	// fifo is the path of the named pipe, or "" for the pipe style
+	fifo string
+
+	// owner is set when this process created the jobserver and must
+	// remove its fifo
+	owner bool
+}
+
+// NewJobserver creates a jobserver of the given style for jobs jobs at
+// once, by default JobserverPipe, which every GNU make version with a
+// jobserver understands; JobserverFifo needs GNU make 4.4 or later. It
+// holds jobs-1 tokens, since every make has one job it may run without a
+// token.
+func NewJobserver(jobs int, style string) (*Jobserver, error) {
+	if jobs < 2 {
+		return nil, fmt.Errorf("a jobserver needs at least two jobs, not %d", jobs)
+	}
+
+	j := &Jobserver{owner: true}
+	switch style {
+	case JobserverFifo:
+		path, err := createFifo()
+		if err != nil {
+			return nil, fmt.Errorf("jobserver: %w", err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			os.Remove(path)
+			return nil, fmt.Errorf("jobserver: %w", err)
+		}
+		j.read, j.write, j.fifo = f, f, path
+	case JobserverPipe, "":
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("jobserver: %w", err)
+		}
+		j.read, j.write = r, w
+	default:
+		return nil, fmt.Errorf("unknown jobserver style '%s'", style)
+	}
+
+	if _, err := j.write.Write([]byte(strings.Repeat("+", jobs-1))); err != nil {
+		j.Close()
+		return nil, fmt.Errorf("jobserver: %w", err)
+	}
+	return j, nil
+}
+
+// createFifo creates a named pipe in the temporary directory, named after
+// the process like GNU make's, and returns its path.
+func createFifo() (string, error) {
+	name := fmt.Sprintf("GMfifo%d", os.Getpid())
+	for i := 1; ; i++ {
+		path := filepath.Join(os.TempDir(), name)
+		err := mkfifo(path)
+		if !errors.Is(err, fs.ErrExist) {
+			return path, err
+		}
+		name = fmt.Sprintf("GMfifo%d-%d", os.Getpid(), i)
+	}
+}
+
+// OpenJobserver joins the jobserver described by auth, the value of
+// --jobserver-auth in MAKEFLAGS: "fifo:PATH" for a named pipe, or "R,W"
+// for the inherited file descriptors of an anonymous pipe.
+func OpenJobserver(auth string) (*Jobserver, error) {
+	if path, ok := strings.CutPrefix(auth, "fifo:"); ok {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("jobserver: %w", err)
+		}
+		return &Jobserver{read: f, write: f, fifo: path}, nil
+	}
+
+	r, w, ok := strings.Cut(auth, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid --jobserver-auth string '%s'", auth)
+	}
+	rfd, rerr := strconv.Atoi(r)
+	wfd, werr := strconv.Atoi(w)
+	if rerr != nil || werr != nil {
+		return nil, fmt.Errorf("invalid --jobserver-auth string '%s'", auth)
+	}
+	if rfd < 0 || wfd < 0 {
+		// A parent make that ran us without the jobserver says so with
+		// negative descriptors
+		return nil, fmt.Errorf("jobserver disabled by the parent make")
+	}
+
+	j := &Jobserver{
+		read:  os.NewFile(uintptr(rfd), "jobserver-read"),
+		write: os.NewFile(uintptr(wfd), "jobserver-write"),
+	}
+	for _, f := range []*os.File{j.read, j.write} {
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("jobserver: file descriptor %d is not open", f.Fd())
+		}
+		// The descriptors are passed on to recursive recipe lines only,
+		// as those of a jobserver created here are
+		closeOnExec(f)
+	}
+	return j, nil
+}
+
+// Auth returns the value of --jobserver-auth that lets child processes
+// join the jobserver. Pipe descriptors are passed to children as file
+// descriptors 3 and 4.
+func (j *Jobserver) Auth() string {
+	if j.fifo != "" {
+		return "fifo:" + j.fifo
+	}
+	return "3,4"
+}
+
+// Close releases the jobserver, removing its fifo if this process created
+// it.
+func (j *Jobserver) Close() error {
+	err := j.read.Close()
+	if j.write != j.read {
+		if werr := j.write.Close(); err == nil {
+			err = werr
+		}
+	}
+	if j.owner && j.fifo != "" {
+		if rerr := os.Remove(j.fifo); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// acquire waits for a token and returns it.
+func (j *Jobserver) acquire() (byte, error) {
+	var token [1]byte
+	for {
+		n, err := j.read.Read(token[:])
+		if n == 1 {
+			return token[0], nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("jobserver: %w", err)
+		}
+	}
+}
+
+// release returns a token to the jobserver.
+func (j *Jobserver) release(token byte) error {
+	if _, err := j.write.Write([]byte{token}); err != nil {
+		return fmt.Errorf("jobserver: %w", err)
+	}
+	return nil
+}
+
+// files returns the files child processes inherit to use the jobserver,
+// in the order that gives them the descriptors named by Auth.
+func (j *Jobserver) files() []*os.File {
+	if j == nil || j.fifo != "" {
+		return nil
+	}
+	return []*os.File{j.read, j.write}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package builder
+
+import (
+	"errors"
+	"os"
+)
+
+// mkfifo creates the named pipe of a fifo style jobserver, which needs
+// named pipes that this platform does not have.
+func mkfifo(path string) error {
+	return errors.New("fifo jobservers are not supported on this platform; use the pipe style")
+}
+
+// closeOnExec does nothing, since this platform does not pass descriptors
+// on to commands unless asked to.
+func closeOnExec(f *os.File) {}
@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+func TestJobserver(t *testing.T) {
+	for _, style := range []string{JobserverFifo, JobserverPipe} {
+		j, err := NewJobserver(3, style)
+		if err != nil {
+			t.Fatalf("NewJobserver(%s) failed: %v", style, err)
+		}
+
+		auth := j.Auth()
+		if style == JobserverFifo && !strings.HasPrefix(auth, "fifo:") || style == JobserverPipe && auth != "3,4" {
+			t.Errorf("Auth() = %q for the %s style", auth, style)
+		}
+
+		// Two tokens are available, one for each job beyond the first
+		for i := 0; i < 2; i++ {
+			token, err := j.acquire()
+			if err != nil || token != '+' {
+				t.Fatalf("acquire() = %q, %v", token, err)
+			}
+		}
+		if err := j.release('+'); err != nil {
+			t.Fatalf("release failed: %v", err)
+		}
+
+		// A client joining a fifo jobserver takes the released token
+		if style == JobserverFifo {
+			client, err := OpenJobserver(auth)
+			if err != nil {
+				t.Fatalf("OpenJobserver(%q) failed: %v", auth, err)
+			}
+			if token, err := client.acquire(); err != nil || token != '+' {
+				t.Errorf("client acquire() = %q, %v", token, err)
+			}
+			client.Close()
+		}
+
+		if err := j.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if style == JobserverFifo {
+			if _, err := os.Stat(strings.TrimPrefix(auth, "fifo:")); !os.IsNotExist(err) {
+				t.Errorf("Expected Close to remove the fifo, got %v", err)
+			}
+		}
+	}
+}
+
+func TestOpenJobserverInvalid(t *testing.T) {
+	for _, auth := range []string{"", "3", "a,b", "-2,-2", "998,999", "fifo:/nonexistent/fifo"} {
+		if j, err := OpenJobserver(auth); err == nil {
+			j.Close()
+			t.Errorf("OpenJobserver(%q) should fail", auth)
+		}
+	}
+	if _, err := NewJobserver(3, "socket"); err == nil {
+		t.Error("NewJobserver should reject an unknown style")
+	}
+}
+
+func TestBuilderJobserver(t *testing.T) {
+	// The three objects wait for each other, so they need the job every
+	// make owns and both tokens of the jobserver
+	wait := "touch $@.start; for i in $$(seq 200); do [ -f a.o.start ] && [ -f b.o.start ] && [ -f c.o.start ] && exit 0; sleep 0.01; done; exit 1"
+	makefile := &types.Makefile{
+		Rules: map[string]*types.Rule{
+			"app": {Target: "app", Dependencies: []string{"a.o", "b.o", "c.o"}, Commands: []string{"touch $@"}},
+			"a.o": {Target: "a.o", Commands: []string{wait}},
+			"b.o": {Target: "b.o", Commands: []string{wait}},
+			"c.o": {Target: "c.o", Commands: []string{wait}},
+		},
+	}
+	makefile.Dir = t.TempDir()
+
+	j, err := NewJobserver(3, JobserverPipe)
+	if err != nil {
+		t.Fatalf("NewJobserver failed: %v", err)
+	}
+	defer j.Close()
+
+	if err := NewBuilder(makefile, WithJobserver(j)).Build("app"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	// Every token is back in the jobserver once the build is over
+	for i := 0; i < 2; i++ {
+		if _, err := j.acquire(); err != nil {
+			t.Fatalf("acquire failed: %v", err)
+		}
+	}
+}
+
+func TestJobserverRecursiveLinesOnly(t *testing.T) {
+	makefile := types.NewMakefile()
+	makefile.Dir = t.TempDir()
+	makefile.Rules["all"] = &types.Rule{Target: "all", Commands: []string{"cc -c a.c", "+recurse", "$(MAKE) -C sub"}}
+
+	j, err := NewJobserver(2, JobserverPipe)
+	if err != nil {
+		t.Fatalf("NewJobserver failed: %v", err)
+	}
+	defer j.Close()
+
+	executor := &recordingExecutor{}
+	if err := NewBuilder(makefile, WithJobserver(j), WithExecutor(executor)).Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := []int{0, 2, 2}
+	if len(executor.commands) != len(want) {
+		t.Fatalf("Ran %d commands, want %d", len(executor.commands), len(want))
+	}
+	for i, cmd := range executor.commands {
+		if len(cmd.ExtraFiles) != want[i] {
+			t.Errorf("%q inherits %d files, want %d", cmd.Argv[len(cmd.Argv)-1], len(cmd.ExtraFiles), want[i])
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package builder
+
+import (
+	"os"
+	"syscall"
+)
+
+// mkfifo creates the named pipe of a fifo style jobserver.
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
+
+// closeOnExec keeps an inherited jobserver descriptor from being passed to
+// every command run.
+func closeOnExec(f *os.File) {
+	syscall.CloseOnExec(int(f.Fd()))
+}
@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// recipeLine is an expanded recipe line with its prefix characters removed.
+type recipeLine struct {
+	command string
+	pos     types.Position
+
+	// silent ('@') lines are not echoed, errors of ignore ('-') lines do
+	// not fail the target, and always ('+') lines run even in a dry run.
+	// Lines that refer to $(MAKE) count as always lines.
+	silent bool
+	ignore bool
+	always bool
+}
+
+// newRecipeLines makes the recipeLines of a line of a recipe, before and
+// after expansion. A line that expands to several lines, such as a canned
+// recipe made with define, yields one recipeLine for each, run in a shell
+// of its own. Prefixes are recognised on the line before expansion, where
+// they apply to every resulting line, and on each line after it, since a
+// variable may expand to one, as in "$(Q)rm -f $@".
+func newRecipeLines(raw, expanded string, pos types.Position) []recipeLine {
+	var template recipeLine
+	template.pos = pos
+	template.stripPrefixes(raw)
+	if strings.Contains(raw, "$(MAKE)") || strings.Contains(raw, "${MAKE}") {
+		template.always = true
+	}
+
+	var lines []recipeLine
+	for _, text := range strings.Split(expanded, "\n") {
+		line := template
+		line.command = line.stripPrefixes(text)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// stripPrefixes records the '@', '-' and '+' characters at the start of
+// text, ignoring whitespace around them, and returns the rest.
+func (l *recipeLine) stripPrefixes(text string) string {
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if text == "" {
+			return text
+		}
+		switch text[0] {
+		case '@':
+			l.silent = true
+		case '-':
+			l.ignore = true
+		case '+':
+			l.always = true
+		default:
+			return text
+		}
+		text = text[1:]
+	}
+}
+
+// runLine runs the line as part of job j, echoing it unless it is silent.
+// In a dry run the line is echoed, silent or not, and only run if it must
+// always run; in touch mode such a line is skipped. A failure of an
+// ignore line is reported on the Builder's stderr, before the next line
+// runs, and then ignored, unless ctx was canceled.
+func (b *Builder) runLine(ctx context.Context, j *job, line recipeLine) error {
+	if b.touch && !b.dryRun && !line.always {
+		return nil
+	}
+	skip := b.dryRun && !line.always
+	if !line.silent || b.dryRun {
+		fmt.Fprintf(b.stdout, "\t%s\n", line.command)
+	}
+	if skip || line.command == "" {
+		return nil
+	}
+
+	err := b.executeCommand(ctx, j.shell, line, j.env)
+	if err != nil && line.ignore && ctx.Err() == nil {
+		prefix := line.pos.String()
+		if prefix != "" {
+			prefix += ": "
+		}
+		fmt.Fprintf(b.stderr, "%s[%s] Error %d (ignored)\n", prefix, j.node.target, exitStatus(err))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// node is a target in the dependency graph of a build.
+type node struct {
+	target string
+
+	// rule is the explicit or implicit rule that makes the target, or nil
+	// for a file that exists without one
+	rule *types.Rule
+
+	// scope holds the target-specific variables the target is built with
+	scope *types.Scope
+
+	// deps are the nodes of the prerequisites, order-only ones included
+	deps []*node
+
+	// err is reported when the node is reached, such as for a target
+	// that has no rule and does not exist
+	err error
+
+	// intermediate is set for a file that does not exist and is made only
+	// to chain implicit rules; it is deleted once the build is over
+	intermediate bool
+
+	state nodeState
+}
+
+// nodeState is the progress of a node through the build.
+type nodeState int
+
+const (
+	nodePending nodeState = iota
+	nodeRunning
+	nodeDone
+
+	// nodeFailed marks a target that failed, or that was not remade
+	// because a prerequisite failed
+	nodeFailed
+)
+
+// job is a recipe ready to run: its lines expanded and its environment
+// computed by the scheduler, so that workers never touch the Makefile.
+type job struct {
+	node  *node
+	lines []recipeLine
+	env   []string
+
+	// shell is the shell and its flags that each line is passed to, as
+	// set by SHELL and .SHELLFLAGS for the target
+	shell []string
+
+	// mtime is the modification time of the target file before the
+	// recipe ran, zero if there was none
+	mtime time.Time
+
+	// phony is set for a prerequisite of .PHONY, which is never touched
+	phony bool
+}
+
+// result reports the outcome of a job.
+type result struct {
+	node *node
+	err  error
+}
+
+// plan builds the dependency graph of target, built on behalf of a target
+// whose variables are in scope. The nodes are appended to order after
+// their prerequisites, so order is the sequence in which a serial build
+// runs the recipes. Each target appears once, with the scope of the target
+// that first causes it to be built.
+func (b *Builder) plan(target string, parent *types.Scope, nodes map[string]*node, order *[]*node) (*node, error) {
+	// Detect circular dependencies
+	if b.building[target] {
+		return nil, fmt.Errorf("circular dependency detected involving target '%s'", target)
+	}
+	if n := nodes[target]; n != nil {
+		return n, nil
+	}
+
+	n := &node{target: target, intermediate: b.intermediate[target] && !b.fileExists(target)}
+	nodes[target] = n
+	if b.built[target] || b.oldFiles[target] {
+		// An old file is not remade, and neither are its prerequisites
+		n.state = nodeDone
+		return n, nil
+	}
+
+	rule, exists := b.makefile.Rules[target]
+	if !exists || len(rule.Commands) == 0 {
+		if implicit := b.implicitRule(target, rule); implicit != nil {
+			rule, exists = implicit, true
+		}
+	}
+	if !exists {
+		// If no rule exists, the target must be a file
+		if _, exists := b.modTime(target); !exists {
+			n.err = fmt.Errorf("no rule to make target '%s'", target)
+		}
+		*order = append(*order, n)
+		return n, nil
+	}
+
+	scope, err := b.makefile.NewScope(target, parent)
+	if err != nil {
+		return nil, err
+	}
+	n.rule = rule
+	n.scope = scope
+
+	b.building[target] = true
+	for _, deps := range [][]string{rule.Dependencies, rule.OrderOnly} {
+		for _, dep := range deps {
+			d, err := b.plan(dep, scope, nodes, order)
+			if err != nil {
+				return nil, err
+			}
+			n.deps = append(n.deps, d)
+		}
+	}
+	b.building[target] = false
+
+	*order = append(*order, n)
+	return n, nil
+}
+
+// schedule is the state of a run of the scheduler.
+type schedule struct {
+	order   []*node
+	results chan result
+	running int
+
+	// failed and notRemade collect the outcome of a keep-going build
+	failed    []*TargetError
+	notRemade []string
+
+	// tokens receives the tokens read from the jobserver; held are those
+	// received and not yet returned, and waiting is set while a token is
+	// being read
+	tokens  chan token
+	done    chan struct{}
+	held    []byte
+	waiting bool
+}
+
+// token is a job token read from the jobserver, or the error reading it.
+type token struct {
+	value byte
+	err   error
+}
+
+// run builds the nodes of order, running as many recipes at once as the
+// job limit or the jobserver allow. A node is started once all its
+// prerequisites are done, taking nodes in order so that a build with one
+// job runs recipes in the order of a serial build. After the first failure
+// no new recipes are started; the running ones are waited for and the
+// first error is returned. In keep-going mode the build carries on with
+// every target that does not depend on a failed one, and the failures are
+// returned together as a *BuildError. When ctx is canceled, the running
+// recipes are stopped and waited for, and a *CanceledError is returned.
+func (b *Builder) run(ctx context.Context, order []*node) error {
+	s := &schedule{
+		order:   order,
+		results: make(chan result),
+		tokens:  make(chan token),
+		done:    make(chan struct{}),
+	}
+	defer close(s.done)
+
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	interrupt := ctx.Done()
+	for {
+		if interrupt != nil && ctx.Err() != nil {
+			// The running recipes are stopped through ctx as well
+			interrupt = nil
+			firstErr = &CanceledError{Err: ctx.Err()}
+		}
+		if firstErr == nil {
+			fail(b.dispatch(ctx, s))
+		}
+		fail(b.returnTokens(s))
+		if s.running == 0 {
+			if firstErr == nil && len(s.failed) > 0 {
+				return &BuildError{Failed: s.failed, NotRemade: s.notRemade}
+			}
+			return firstErr
+		}
+
+		select {
+		case <-interrupt:
+		case r := <-s.results:
+			s.running--
+			if r.err != nil && ctx.Err() != nil {
+				// Stopped by the cancellation, which is the error reported
+				r.node.state = nodeFailed
+				continue
+			}
+			if r.err != nil {
+				fail(b.targetFailed(s, r.node, r.err))
+				continue
+			}
+			if b.dryRun {
+				b.mtimes[r.node.target] = time.Now()
+			}
+			b.finish(r.node)
+		case t := <-s.tokens:
+			s.waiting = false
+			if t.err != nil {
+				fail(t.err)
+				continue
+			}
+			s.held = append(s.held, t.value)
+		}
+	}
+}
+
+// dispatch starts every node that is ready, while job slots are free.
+// Nodes whose recipe need not run are completed on the spot, which may make
+// later nodes ready in the same pass, since a node always comes after its
+// prerequisites. So are nodes that cannot be remade because a prerequisite
+// failed.
+func (b *Builder) dispatch(ctx context.Context, s *schedule) error {
+	for _, n := range s.order {
+		if n.state != nodePending || !n.ready() {
+			continue
+		}
+		if n.depFailed() {
+			n.state = nodeFailed
+			s.notRemade = append(s.notRemade, n.target)
+			continue
+		}
+		if n.err != nil {
+			if err := b.targetFailed(s, n, n.err); err != nil {
+				return err
+			}
+			continue
+		}
+		if n.rule == nil || !b.needsRebuild(n.target, n.rule.Dependencies) {
+			b.finish(n)
+			continue
+		}
+		if b.question {
+			// Nothing runs or is printed in question mode; a target
+			// without a recipe has nothing to remake
+			if len(n.rule.Commands) > 0 {
+				return ErrNotUpToDate
+			}
+			b.finish(n)
+			continue
+		}
+		if !b.slotFree(s) {
+			return nil
+		}
+
+		j, err := b.prepare(n)
+		if err != nil {
+			if err := b.targetFailed(s, n, err); err != nil {
+				return err
+			}
+			continue
+		}
+		n.state = nodeRunning
+		s.running++
+		go func(n *node) {
+			s.results <- result{node: n, err: b.execute(ctx, j)}
+		}(n)
+	}
+	return nil
+}
+
+// slotFree reports whether another recipe may start. With a jobserver the
+// first recipe runs on the token every make owns and each further one
+// needs a token from the jobserver; if none is held, one is requested.
+func (b *Builder) slotFree(s *schedule) bool {
+	if b.jobserver == nil {
+		return b.jobs <= 0 || s.running < b.jobs
+	}
+	if s.running < 1+len(s.held) {
+		return true
+	}
+	if !s.waiting {
+		s.waiting = true
+		go func() {
+			value, err := b.jobserver.acquire()
+			select {
+			case s.tokens <- token{value: value, err: err}:
+			case <-s.done:
+				// The build is over; hand the token back
+				if err == nil {
+					b.jobserver.release(value)
+				}
+			}
+		}()
+	}
+	return false
+}
+
+// returnTokens gives back the tokens that running recipes no longer need.
+func (b *Builder) returnTokens(s *schedule) error {
+	for len(s.held) > 0 && len(s.held) >= s.running {
+		value := s.held[len(s.held)-1]
+		s.held = s.held[:len(s.held)-1]
+		if err := b.jobserver.release(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ready reports whether all prerequisites of n are done or have failed.
+func (n *node) ready() bool {
+	for _, dep := range n.deps {
+		if dep.state != nodeDone && dep.state != nodeFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// depFailed reports whether a prerequisite of n has failed.
+func (n *node) depFailed() bool {
+	for _, dep := range n.deps {
+		if dep.state == nodeFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// targetFailed records that n failed with err. It returns err, which stops
+// the build, unless the build keeps going.
+func (b *Builder) targetFailed(s *schedule, n *node, err error) error {
+	n.state = nodeFailed
+	if !b.keepGoing {
+		return err
+	}
+	s.failed = append(s.failed, &TargetError{Target: n.target, ExitStatus: exitStatus(err), Err: err})
+	return nil
+}
+
+// finish marks n as done, and its target as built if it has a rule.
+func (b *Builder) finish(n *node) {
+	n.state = nodeDone
+	if n.rule != nil {
+		b.built[n.target] = true
+	}
+}
+
+// prepare expands the recipe of n. As in GNU make, every line is expanded
+// before the first one runs. Expansion errors, such as those raised by
+// $(error), are reported at the line they occur on.
+func (b *Builder) prepare(n *node) (*job, error) {
+	// A dry run prints only the commands, and touch mode what it touches
+	if !b.dryRun && !b.touch {
+		fmt.Fprintf(b.stdout, "Building target: %s\n", n.target)
+	}
+
+	// Create automatic variables context
+	autoVars := b.createAutomaticVariables(n.rule)
+
+	j := &job{node: n}
+	for i, command := range n.rule.Commands {
+		pos := n.rule.CommandPosition(i)
+		expanded, err := b.makefile.ExpandInScope(command, n.scope, autoVars, pos)
+		if err != nil {
+			return nil, err
+		}
+		j.lines = append(j.lines, newRecipeLines(command, expanded, pos)...)
+	}
+
+	env, err := b.makefile.RecipeEnvironment(n.scope)
+	if err != nil {
+		return nil, types.ErrorAt(n.rule.CommandPosition(0), err)
+	}
+	j.env = env
+
+	j.shell, err = b.makefile.ShellInScope(n.scope, autoVars, n.rule.CommandPosition(0))
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(b.makefile.Path(n.target)); err == nil {
+		j.mtime = info.ModTime()
+	}
+	j.phony = b.hasSpecialPrereq(".PHONY", n.target)
+	return j, nil
+}
+
+// execute runs the lines of a job one after another, stopping at the first
+// that fails. In touch mode the target is then touched, unless it is phony.
+func (b *Builder) execute(ctx context.Context, j *job) error {
+	for _, line := range j.lines {
+		if err := b.runLine(ctx, j, line); err != nil {
+			if ctx.Err() != nil {
+				b.removeInterrupted(j)
+			}
+			return err
+		}
+	}
+	if !b.touch || len(j.lines) == 0 || j.phony {
+		return nil
+	}
+	fmt.Fprintf(b.stdout, "touch %s\n", j.node.target)
+	if b.dryRun {
+		return nil
+	}
+	return b.touchFile(j.node.target)
+}
+
+// removeInterrupted deletes the target file of j if its recipe was stopped
+// after changing it, as GNU make does, so that a partly written file is not
+// taken for an up to date target by the next build.
+func (b *Builder) removeInterrupted(j *job) {
+	path := b.makefile.Path(j.node.target)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.ModTime().Equal(j.mtime) {
+		return
+	}
+	fmt.Fprintf(b.stderr, "*** Deleting file '%s'\n", j.node.target)
+	os.Remove(path)
+}
@@ -4,7 +4,14 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/5l0p/go-make/pkg/builder"
 	"github.com/5l0p/go-make/pkg/makefile"
@@ -16,6 +23,307 @@ import (
 type Make struct {
 	makefile *types.Makefile
 	builder  *builder.Builder
+
+	// Settings applied by options before the Makefile is parsed
+	program            string
+	goals              []string
+	noBuiltinVariables bool
+	noBuiltinRules     bool
+	keepGoing          bool
+	dryRun             bool
+	touch              bool
+	question           bool
+	alwaysMake         bool
+	builderOptions     []builder.Option
+
+	// executor and stderr, when set, run the commands of $(shell) and
+	// receive their standard error as they do for recipes
+	executor builder.Executor
+	stderr   io.Writer
+
+	// jobs is the -j limit, zero for none. jobserverAuth is the jobserver
+	// of a parent make to join, and jobserver the one in use, if any.
+	jobs           int
+	jobserverAuth  string
+	jobserverStyle string
+	jobserver      *builder.Jobserver
+}
+
+// Option configures a Make instance created by New. Options are applied
+// before the Makefile is parsed.
+type Option func(*Make) error
+
+// WithDiagnostics sends the output of $(warning), $(info) and other
+// diagnostics to w instead of the standard streams.
+func WithDiagnostics(w io.Writer) Option {
+	return func(m *Make) error {
+		m.makefile.Diagnostics = w
+		return nil
+	}
+}
+
+// WithVariable defines a command line variable, as "make NAME=value" does.
+// It takes precedence over assignments in the Makefile, except those using
+// the override directive, and is passed to sub-makes through MAKEFLAGS.
+func WithVariable(name, value string) Option {
+	return func(m *Make) error {
+		return m.makefile.AssignOrigin(name, types.AssignRecursive, value, types.OriginCommandLine)
+	}
+}
+
+// WithAssignments defines command line variables from assignments written
+// as on the make command line, such as "CC=clang" or "FLAGS:=-O2".
+func WithAssignments(assignments ...string) Option {
+	return func(m *Make) error {
+		for _, assignment := range assignments {
+			name, op, value, ok := types.ParseAssignment(assignment)
+			if !ok {
+				return fmt.Errorf("invalid variable assignment '%s'", assignment)
+			}
+			if err := m.makefile.AssignOrigin(name, op, value, types.OriginCommandLine); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithEnvironmentOverrides gives environment variables precedence over
+// assignments in the Makefile, like make -e.
+func WithEnvironmentOverrides() Option {
+	return func(m *Make) error {
+		m.makefile.EnvironmentOverrides = true
+		return nil
+	}
+}
+
+// WithoutBuiltinVariables leaves the built-in variables such as CC and RM
+// undefined, like make -R. It also disables the built-in rules.
+func WithoutBuiltinVariables() Option {
+	return func(m *Make) error {
+		m.noBuiltinVariables = true
+		m.noBuiltinRules = true
+		return nil
+	}
+}
+
+// WithoutBuiltinRules disables the built-in implicit rules, like make -r.
+func WithoutBuiltinRules() Option {
+	return func(m *Make) error {
+		m.noBuiltinRules = true
+		return nil
+	}
+}
+
+// WithKeepGoing keeps building after a recipe fails, like make -k. Every
+// target that does not depend on a failed one is built, and the build
+// returns an error listing all the failures.
+func WithKeepGoing() Option {
+	return func(m *Make) error {
+		m.keepGoing = true
+		m.builderOptions = append(m.builderOptions, builder.WithKeepGoing())
+		return nil
+	}
+}
+
+// WithDryRun prints the commands that would be run without running them,
+// like make -n, except for lines prefixed with '+' and lines that run
+// $(MAKE). Sub-makes inherit the option through MAKEFLAGS.
+func WithDryRun() Option {
+	return func(m *Make) error {
+		m.dryRun = true
+		m.builderOptions = append(m.builderOptions, builder.WithDryRun())
+		return nil
+	}
+}
+
+// WithTouch touches out of date targets instead of running their recipes,
+// like make -t.
+func WithTouch() Option {
+	return func(m *Make) error {
+		m.touch = true
+		m.builderOptions = append(m.builderOptions, builder.WithTouch())
+		return nil
+	}
+}
+
+// WithQuestion runs nothing, like make -q: building returns an error
+// matching builder.ErrNotUpToDate if any target needs to be remade.
+func WithQuestion() Option {
+	return func(m *Make) error {
+		m.question = true
+		m.builderOptions = append(m.builderOptions, builder.WithQuestion())
+		return nil
+	}
+}
+
+// WithAlwaysMake treats every target as out of date, like make -B.
+func WithAlwaysMake() Option {
+	return func(m *Make) error {
+		m.alwaysMake = true
+		m.builderOptions = append(m.builderOptions, builder.WithAlwaysMake())
+		return nil
+	}
+}
+
+// WithWhatIf acts as if files had just been modified, like make -W.
+// Combined with WithDryRun it shows what editing them would remake.
+func WithWhatIf(files ...string) Option {
+	return func(m *Make) error {
+		m.builderOptions = append(m.builderOptions, builder.WithWhatIf(files...))
+		return nil
+	}
+}
+
+// WithOldFiles treats files as very old and never remakes them, like
+// make -o.
+func WithOldFiles(files ...string) Option {
+	return func(m *Make) error {
+		m.builderOptions = append(m.builderOptions, builder.WithOldFiles(files...))
+		return nil
+	}
+}
+
+// WithExecutor runs commands with e instead of as local processes: the
+// recipes of targets, and the commands of $(shell) and != assignments.
+func WithExecutor(e builder.Executor) Option {
+	return func(m *Make) error {
+		m.builderOptions = append(m.builderOptions, builder.WithExecutor(e))
+		m.executor = e
+		return nil
+	}
+}
+
+// WithOutput sends the output of recipes, with the commands echoed and the
+// progress messages, to stdout and their errors to stderr, instead of the
+// standard streams. The errors of $(shell) commands go to stderr too.
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(m *Make) error {
+		// $(shell) may run while recipes write, so its writes are
+		// serialized with those of the builder
+		stdout, stderr = builder.SyncOutput(stdout, stderr)
+		m.builderOptions = append(m.builderOptions, builder.WithOutput(stdout, stderr))
+		m.stderr = stderr
+		return nil
+	}
+}
+
+// commandRunner runs the commands of $(shell) with the executor set by
+// WithExecutor, capturing their standard output and writing their standard
+// error to the writer set by WithOutput.
+func (m *Make) commandRunner() types.CommandRunner {
+	e, stderr := m.executor, m.stderr
+	if e == nil {
+		e = builder.ProcessExecutor{}
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	return func(argv []string, dir string, env []string) ([]byte, int, error) {
+		var stdout bytes.Buffer
+		status, err := e.Run(context.Background(), &builder.Command{
+			Argv:   argv,
+			Dir:    dir,
+			Env:    env,
+			Stdout: &stdout,
+			Stderr: stderr,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return stdout.Bytes(), status, nil
+	}
+}
+
+// WithProgram sets the command that $(MAKE) runs in recipes. It defaults
+// to the name the current program was started with.
+func WithProgram(path string) Option {
+	return func(m *Make) error {
+		m.program = path
+		return nil
+	}
+}
+
+// WithGoals sets the targets given on the command line, which the
+// Makefile sees in $(MAKECMDGOALS).
+func WithGoals(goals ...string) Option {
+	return func(m *Make) error {
+		m.goals = append(m.goals, goals...)
+		return nil
+	}
+}
+
+// WithJobs lets up to n recipes run at once, like make's -j option. If n
+// is zero or negative, the number of recipes run at once is not limited.
+// With a limit above one, a jobserver shares it with the sub-makes run by
+// recipes. WithJobs overrides a jobserver inherited through WithMakeFlags.
+func WithJobs(n int) Option {
+	return func(m *Make) error {
+		m.jobs = n
+		m.jobserverAuth = ""
+		return nil
+	}
+}
+
+// WithJobserverStyle selects how the jobserver created for WithJobs is
+// shared: builder.JobserverPipe, the default, or builder.JobserverFifo,
+// which sub-makes older than GNU make 4.4 do not understand.
+func WithJobserverStyle(style string) Option {
+	return func(m *Make) error {
+		switch style {
+		case builder.JobserverFifo, builder.JobserverPipe:
+			m.jobserverStyle = style
+			return nil
+		}
+		return fmt.Errorf("unknown jobserver style '%s'", style)
+	}
+}
+
+// WithMakeFlags applies the options and command line variables that a
+// parent make passed down in MAKEFLAGS.
+func WithMakeFlags(value string) Option {
+	return func(m *Make) error {
+		flags := ParseMakeFlags(value)
+		for _, option := range flags.Options {
+			switch {
+			case option == "-j":
+				m.jobs = 0
+			case strings.HasPrefix(option, "-j"):
+				if n, err := strconv.Atoi(option[2:]); err == nil {
+					m.jobs = n
+				}
+			case strings.HasPrefix(option, "--jobserver-auth="):
+				m.jobserverAuth = strings.TrimPrefix(option, "--jobserver-auth=")
+			}
+		}
+		for _, letter := range flags.Letters {
+			var opt Option
+			switch letter {
+			case 'e':
+				opt = WithEnvironmentOverrides()
+			case 'r':
+				opt = WithoutBuiltinRules()
+			case 'R':
+				opt = WithoutBuiltinVariables()
+			case 'k':
+				opt = WithKeepGoing()
+			case 'n':
+				opt = WithDryRun()
+			case 't':
+				opt = WithTouch()
+			case 'q':
+				opt = WithQuestion()
+			case 'B':
+				opt = WithAlwaysMake()
+			default:
+				continue
+			}
+			if err := opt(m); err != nil {
+				return err
+			}
+		}
+		return WithAssignments(flags.Variables...)(m)
+	}
 }
 
 // New creates a new Make instance by parsing the specified Makefile.
@@ -26,20 +334,174 @@ type Make struct {
 //   if err != nil {
 //       log.Fatal(err)
 //   }
-func New(filename string) (*Make, error) {
+func New(filename string, opts ...Option) (*Make, error) {
 	if filename == "" {
 		filename = "Makefile"
 	}
 
-	mf, err := makefile.ParseMakefile(filename)
-	if err != nil {
+	m := &Make{makefile: types.NewMakefile(), jobs: 1}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.executor != nil || m.stderr != nil {
+		m.makefile.RunCommand = m.commandRunner()
+	}
+	if err := m.startJobserver(); err != nil {
+		return nil, err
+	}
+	if err := m.load(filename); err != nil {
+		// The jobserver is not handed to the caller, so it must not outlive
+		// the failure
+		m.Close()
 		return nil, err
 	}
 
-	return &Make{
-		makefile: mf,
-		builder:  builder.NewBuilder(mf),
-	}, nil
+	m.builder = builder.NewBuilder(m.makefile, m.builderOptions...)
+	return m, nil
+}
+
+// load defines the variables and built-in rules that every Makefile gets,
+// then reads filename.
+func (m *Make) load(filename string) error {
+	if err := m.defineMakeVariables(); err != nil {
+		return err
+	}
+	m.exportMakeFlags()
+	if !m.noBuiltinRules {
+		if err := makefile.DefineBuiltinRules(m.makefile); err != nil {
+			return err
+		}
+	}
+	return makefile.ParseFile(m.makefile, filename)
+}
+
+// startJobserver joins the jobserver of a parent make, or creates one when
+// more than one job may run. A sub-make that cannot reach its parent's
+// jobserver runs one job at a time, as GNU make does.
+func (m *Make) startJobserver() error {
+	switch {
+	case m.jobserverAuth != "":
+		jobserver, err := builder.OpenJobserver(m.jobserverAuth)
+		if err != nil {
+			m.makefile.Warn(types.Position{}, "go-make: warning: jobserver unavailable: using -j1.  Add '+' to parent make rule.")
+			m.jobs = 1
+			m.jobserverAuth = ""
+			break
+		}
+		// Children reach the jobserver through this make, which may pass
+		// it to them differently from how it was passed to it
+		m.jobserver = jobserver
+		m.jobserverAuth = jobserver.Auth()
+	case m.jobs > 1:
+		jobserver, err := builder.NewJobserver(m.jobs, m.jobserverStyle)
+		if err != nil {
+			return err
+		}
+		m.jobserver = jobserver
+		m.jobserverAuth = jobserver.Auth()
+	}
+
+	if m.jobserver != nil {
+		m.builderOptions = append(m.builderOptions, builder.WithJobserver(m.jobserver))
+	} else {
+		m.builderOptions = append(m.builderOptions, builder.WithJobs(m.jobs))
+	}
+	return nil
+}
+
+// Close releases the resources held by m, such as the fifo of its
+// jobserver. The Make must not be used to build afterwards.
+func (m *Make) Close() error {
+	if m.jobserver == nil {
+		return nil
+	}
+	err := m.jobserver.Close()
+	m.jobserver = nil
+	return err
+}
+
+// defineMakeVariables defines the variables that make provides to every
+// Makefile, and the built-in variables unless they are disabled.
+func (m *Make) defineMakeVariables() error {
+	mf := m.makefile
+
+	program := m.program
+	if program == "" {
+		program = os.Args[0]
+	}
+	curdir := mf.Dir
+	if curdir == "" {
+		var err error
+		if curdir, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	curdir, err := filepath.Abs(curdir)
+	if err != nil {
+		return err
+	}
+
+	level := "0"
+	if env, ok := os.LookupEnv("MAKELEVEL"); ok {
+		level = env
+	}
+
+	mf.DefineOrigin("MAKE_COMMAND", program, types.Simple, types.OriginDefault)
+	mf.DefineOrigin("MAKE", "$(MAKE_COMMAND)", types.Recursive, types.OriginDefault)
+	mf.DefineOrigin("MAKELEVEL", level, types.Simple, types.OriginEnvironment)
+	mf.DefineOrigin("MAKECMDGOALS", strings.Join(m.goals, " "), types.Simple, types.OriginDefault)
+	mf.DefineOrigin("MAKE_VERSION", types.MakeVersion, types.Simple, types.OriginDefault)
+	mf.DefineOrigin(".FEATURES", strings.Join(types.Features, " "), types.Simple, types.OriginDefault)
+	mf.DefineOrigin("SHELL", types.DefaultShell, types.Recursive, types.OriginDefault)
+	mf.DefineOrigin("CURDIR", curdir, types.Simple, types.OriginFile)
+
+	if !m.noBuiltinVariables {
+		mf.DefineDefaults()
+	}
+	return nil
+}
+
+// exportMakeFlags sets MAKEFLAGS so that sub-makes run from recipes inherit
+// the options and command line variables of this one.
+func (m *Make) exportMakeFlags() {
+	var flags MakeFlags
+	if m.makefile.EnvironmentOverrides {
+		flags.Letters += "e"
+	}
+	if m.keepGoing {
+		flags.Letters += "k"
+	}
+	if m.dryRun {
+		flags.Letters += "n"
+	}
+	if m.touch {
+		flags.Letters += "t"
+	}
+	if m.question {
+		flags.Letters += "q"
+	}
+	if m.alwaysMake {
+		flags.Letters += "B"
+	}
+	if m.noBuiltinRules {
+		flags.Letters += "r"
+	}
+	if m.noBuiltinVariables {
+		flags.Letters += "R"
+	}
+	switch {
+	case m.jobs <= 0:
+		flags.Options = append(flags.Options, "-j")
+	case m.jobserverAuth != "":
+		flags.Options = append(flags.Options, "-j"+strconv.Itoa(m.jobs), "--jobserver-auth="+m.jobserverAuth)
+	}
+	flags.Variables = commandLineVariables(m.makefile)
+
+	// The value is set directly, since with -e a MAKEFLAGS in the
+	// environment would otherwise keep it from being defined
+	m.makefile.Variables["MAKEFLAGS"] = &types.Variable{Name: "MAKEFLAGS", Value: flags.String(), Flavor: types.Simple, Origin: types.OriginFile}
 }
 
 // NewFromMakefile creates a new Make instance from an existing parsed Makefile.
@@ -58,6 +520,12 @@ func NewFromMakefile(mf *types.Makefile) *Make {
 //       log.Fatal(err)
 //   }
 func (m *Make) Build(target string) error {
+	return m.build(context.Background(), target)
+}
+
+// build builds target, or the default target if target is empty, until ctx
+// is canceled.
+func (m *Make) build(ctx context.Context, target string) error {
 	if target == "" {
 		target = m.makefile.FirstRule
 	}
@@ -66,11 +534,14 @@ func (m *Make) Build(target string) error {
 		return fmt.Errorf("no targets found in Makefile")
 	}
 
-	return m.builder.Build(target)
+	return m.builder.BuildContext(ctx, target)
 }
 
-// BuildMultiple builds multiple targets in sequence.
-// If any target fails, the process stops and returns an error.
+// BuildMultiple builds multiple targets as one build, in which, with
+// WithJobs, the recipes of different targets may run at once.
+// If any target fails, the process stops and returns an error. With
+// WithKeepGoing the remaining targets are still built, and the errors of
+// all failed targets are returned together in a *builder.BuildError.
 //
 // Example:
 //   err := make.BuildMultiple("clean", "build", "test")
@@ -78,12 +549,24 @@ func (m *Make) Build(target string) error {
 //       log.Fatal(err)
 //   }
 func (m *Make) BuildMultiple(targets ...string) error {
-	for _, target := range targets {
-		if err := m.Build(target); err != nil {
-			return fmt.Errorf("failed to build target '%s': %w", target, err)
-		}
+	return m.builder.BuildContext(context.Background(), targets...)
+}
+
+// BuildContext builds targets as one build like BuildMultiple, or the
+// default target if none are given, until ctx is canceled. Canceling ctx
+// stops the running recipes and everything they started, and the build
+// returns a *builder.CanceledError, even with WithKeepGoing. The targets
+// built until then stay built.
+//
+// Example:
+//   ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+//   defer cancel()
+//   err := make.BuildContext(ctx, "build", "test")
+func (m *Make) BuildContext(ctx context.Context, targets ...string) error {
+	if len(targets) == 0 {
+		return m.build(ctx, "")
 	}
-	return nil
+	return m.builder.BuildContext(ctx, targets...)
 }
 
 // HasTarget returns true if the Makefile contains the specified target.
@@ -116,6 +599,12 @@ func (m *Make) Reset() {
 	m.builder.Reset()
 }
 
+// PrintDatabase writes the variables and rules read from the Makefile,
+// including the built-in ones, to w, like make -p.
+func (m *Make) PrintDatabase(w io.Writer) error {
+	return m.makefile.PrintDatabase(w)
+}
+
 // Makefile returns the underlying parsed Makefile for advanced usage.
 func (m *Make) Makefile() *types.Makefile {
 	return m.makefile
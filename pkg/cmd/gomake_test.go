@@ -0,0 +1,418 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5l0p/go-make/pkg/builder"
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// writeMakefile writes content to a Makefile in a temporary directory and
+// returns its path.
+func writeMakefile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMakeVariables(t *testing.T) {
+	t.Setenv("MAKELEVEL", "2")
+	t.Setenv("SHELL", "/bin/false")
+	path := writeMakefile(t, "CC = clang\nall:\n")
+
+	make, err := New(path, WithProgram("/usr/bin/go-make"), WithGoals("all", "check"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	mf := make.Makefile()
+
+	wd, _ := os.Getwd()
+	tests := []struct {
+		name   string
+		value  string
+		origin types.Origin
+	}{
+		{"MAKE", "/usr/bin/go-make", types.OriginDefault},
+		{"MAKELEVEL", "2", types.OriginEnvironment},
+		{"MAKECMDGOALS", "all check", types.OriginDefault},
+		{"MAKE_VERSION", types.MakeVersion, types.OriginDefault},
+		{".FEATURES", "order-only target-specific undefine jobserver jobserver-fifo", types.OriginDefault},
+		{"SHELL", "/bin/sh", types.OriginDefault},
+		{"CURDIR", wd, types.OriginFile},
+		{"CC", "clang", types.OriginFile},
+		{"RM", "rm -f", types.OriginDefault},
+		{"CFLAGS", "", types.OriginUndefined},
+	}
+	for _, test := range tests {
+		if got := mf.ExpandVariables("$(" + test.name + ")"); got != test.value {
+			t.Errorf("$(%s) = %q, want %q", test.name, got, test.value)
+		}
+		if got := mf.Origin(test.name); got != test.origin {
+			t.Errorf("origin of %s = %v, want %v", test.name, got, test.origin)
+		}
+	}
+
+	found := false
+	for _, entry := range mf.Environment() {
+		if entry == "MAKELEVEL=3" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected MAKELEVEL=3 in the environment of commands")
+	}
+}
+
+func TestWithoutBuiltinVariables(t *testing.T) {
+	path := writeMakefile(t, "all:\n")
+
+	make, err := New(path, WithoutBuiltinVariables())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	mf := make.Makefile()
+
+	if mf.HasVariable("CC") || mf.HasVariable("COMPILE.c") {
+		t.Error("built-in variables should not be defined with -R")
+	}
+	if !mf.HasVariable("MAKE") {
+		t.Error("MAKE should be defined even with -R")
+	}
+	if len(mf.BuiltinRules) != 0 {
+		t.Error("built-in rules should not be defined with -R")
+	}
+	if got := mf.GetVariable("MAKEFLAGS"); got != "rR" {
+		t.Errorf("MAKEFLAGS = %q, want %q", got, "rR")
+	}
+}
+
+func TestDefaultsYieldToMakefile(t *testing.T) {
+	path := writeMakefile(t, "CC ?= gcc\nCFLAGS ?= -O2\nall:\n")
+
+	make, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	mf := make.Makefile()
+
+	// As in GNU make, ?= does not replace a built-in default
+	if got := mf.ExpandVariables("$(CC) $(CFLAGS)"); got != "cc -O2" {
+		t.Errorf("$(CC) $(CFLAGS) = %q, want %q", got, "cc -O2")
+	}
+}
+
+func TestBuiltinRulesOption(t *testing.T) {
+	path := writeMakefile(t, "all:\n")
+
+	make, err := New(path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if len(make.Makefile().BuiltinRules) == 0 {
+		t.Error("expected the built-in rules to be defined")
+	}
+
+	make, err = New(path, WithoutBuiltinRules())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if len(make.Makefile().BuiltinRules) != 0 {
+		t.Error("built-in rules should not be defined with -r")
+	}
+	if !make.Makefile().HasVariable("CC") {
+		t.Error("built-in variables should still be defined with -r")
+	}
+}
+
+func TestJobserverMakeFlags(t *testing.T) {
+	path := writeMakefile(t, "all:\n")
+
+	// The pipe style is the default, since sub-makes before GNU make 4.4
+	// do not understand fifos
+	piped, err := New(path, WithJobs(4))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer piped.Close()
+	if flags := piped.Makefile().GetVariable("MAKEFLAGS"); flags != "-j4 --jobserver-auth=3,4" {
+		t.Errorf("MAKEFLAGS = %q, want a pipe jobserver", flags)
+	}
+
+	parent, err := New(path, WithJobs(4), WithJobserverStyle(builder.JobserverFifo))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer parent.Close()
+	flags := parent.Makefile().GetVariable("MAKEFLAGS")
+	if !strings.HasPrefix(flags, "-j4 --jobserver-auth=fifo:") {
+		t.Fatalf("MAKEFLAGS = %q, want the job limit and the jobserver", flags)
+	}
+
+	// A sub-make joins the jobserver and passes it on
+	child, err := New(path, WithMakeFlags(flags))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer child.Close()
+	if child.jobserver == nil {
+		t.Error("Expected the sub-make to join the jobserver")
+	}
+	if got := child.Makefile().GetVariable("MAKEFLAGS"); got != flags {
+		t.Errorf("sub-make MAKEFLAGS = %q, want %q", got, flags)
+	}
+
+	// One that cannot reach it runs one job at a time
+	var diagnostics strings.Builder
+	orphan, err := New(path, WithDiagnostics(&diagnostics), WithMakeFlags("-j4 --jobserver-auth=998,999"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if orphan.jobserver != nil || orphan.jobs != 1 {
+		t.Errorf("Expected -j1 without a jobserver, got %d jobs", orphan.jobs)
+	}
+	if !strings.Contains(diagnostics.String(), "jobserver unavailable") {
+		t.Errorf("Expected a warning, got %q", diagnostics.String())
+	}
+	if got := orphan.Makefile().GetVariable("MAKEFLAGS"); got != "" {
+		t.Errorf("MAKEFLAGS = %q, want none", got)
+	}
+
+	// -j without a limit needs no jobserver
+	unlimited, err := New(path, WithJobs(0))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if unlimited.jobserver != nil || unlimited.Makefile().GetVariable("MAKEFLAGS") != "-j" {
+		t.Errorf("Unexpected jobserver for -j, MAKEFLAGS %q", unlimited.Makefile().GetVariable("MAKEFLAGS"))
+	}
+}
+
+func TestEnvironmentOverridesMakeFlags(t *testing.T) {
+	// With -e, a MAKEFLAGS in the environment does not keep this make's
+	// from being passed on
+	t.Setenv("MAKEFLAGS", "s")
+	path := writeMakefile(t, "all:\n")
+
+	make, err := New(path, WithEnvironmentOverrides(), WithJobs(4), WithAssignments("X=1"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer make.Close()
+	if got, want := make.Makefile().GetVariable("MAKEFLAGS"), "e -j4 --jobserver-auth=3,4 -- X=1"; got != want {
+		t.Errorf("MAKEFLAGS = %q, want %q", got, want)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	path := writeMakefile(t, "bad:\n\texit 1\nworse:\n\texit 2\ngood:\n\ttouch $@\n")
+
+	make, err := New(path, WithKeepGoing())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	make.Makefile().Dir = filepath.Dir(path)
+	if got := make.Makefile().GetVariable("MAKEFLAGS"); got != "k" {
+		t.Errorf("MAKEFLAGS = %q, want %q", got, "k")
+	}
+
+	err = make.BuildMultiple("bad", "good", "worse")
+	var buildErr *builder.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("Expected a *builder.BuildError, got %v", err)
+	}
+	for _, want := range []string{"target 'bad' failed", "target 'worse' failed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %q", want, err)
+		}
+	}
+	if !make.IsBuilt("good") {
+		t.Error("Expected good to be built after bad failed")
+	}
+}
+
+func TestParallelGoals(t *testing.T) {
+	// Each goal waits for the other to start, so they only succeed if
+	// they run at once
+	wait := "\ttouch $@.start; for i in $$(seq 200); do [ -f %s.start ] && exit 0; sleep 0.01; done; exit 1\n"
+	path := writeMakefile(t, "a:\n"+fmt.Sprintf(wait, "b")+"b:\n"+fmt.Sprintf(wait, "a"))
+
+	make, err := New(path, WithJobs(2))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer make.Close()
+	make.Makefile().Dir = filepath.Dir(path)
+	if err := make.BuildMultiple("a", "b"); err != nil {
+		t.Fatalf("Expected the goals to run at once, got %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	path := writeMakefile(t, "out:\n\ttouch $@\n")
+
+	for _, opt := range []Option{WithDryRun(), WithMakeFlags("n")} {
+		make, err := New(path, opt)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		make.Makefile().Dir = filepath.Dir(path)
+		if got := make.Makefile().GetVariable("MAKEFLAGS"); got != "n" {
+			t.Errorf("MAKEFLAGS = %q, want %q", got, "n")
+		}
+		if err := make.Build("out"); err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if _, err := os.Stat(make.Makefile().Path("out")); err == nil {
+			t.Error("Expected a dry run not to create out")
+		}
+	}
+}
+
+func TestTouchQuestionAlwaysMake(t *testing.T) {
+	path := writeMakefile(t, "out:\n\ttouch $@\n")
+
+	make, err := New(path, WithMakeFlags("tqB"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	make.Makefile().Dir = filepath.Dir(path)
+	if got := make.Makefile().GetVariable("MAKEFLAGS"); got != "tqB" {
+		t.Errorf("MAKEFLAGS = %q, want %q", got, "tqB")
+	}
+	if err := make.BuildMultiple("out"); !errors.Is(err, builder.ErrNotUpToDate) {
+		t.Errorf("Expected builder.ErrNotUpToDate, got %v", err)
+	}
+}
+
+func TestTouchPhonyTargets(t *testing.T) {
+	// Each .PHONY line adds to the phony targets
+	path := writeMakefile(t, ".PHONY: all\n.PHONY: clean\nall: out\n\t@echo done\nclean:\n\trm -f out\nout:\n\ttouch $@\n")
+
+	make, err := New(path, WithTouch())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	make.Makefile().Dir = filepath.Dir(path)
+	if err := make.BuildMultiple("all", "clean"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	for name, want := range map[string]bool{"out": true, "all": false, "clean": false} {
+		if _, err := os.Stat(make.Makefile().Path(name)); (err == nil) != want {
+			t.Errorf("Expected %s to be touched: %v", name, want)
+		}
+	}
+}
+
+// echoExecutor writes the command it is given to its standard output
+// instead of running it.
+type echoExecutor struct {
+	commands []string
+}
+
+func (e *echoExecutor) Run(ctx context.Context, cmd *builder.Command) (int, error) {
+	command := strings.Join(cmd.Argv, " ")
+	e.commands = append(e.commands, command)
+	fmt.Fprint(cmd.Stdout, command)
+	return 0, nil
+}
+
+func TestExecutor(t *testing.T) {
+	path := writeMakefile(t, "OUT := $(shell date)\nall:\n\tbuild $(OUT)\n")
+
+	executor := &echoExecutor{}
+	make, err := New(path, WithExecutor(executor))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := make.Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := []string{"/bin/sh -c date", "/bin/sh -c build /bin/sh -c date"}
+	if strings.Join(executor.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Ran %q, want %q", executor.commands, want)
+	}
+}
+
+func TestBuildContext(t *testing.T) {
+	path := writeMakefile(t, "all:\n\tsleep 30\nout:\n\ttouch $@\n")
+
+	make, err := New(path, WithKeepGoing())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	make.Makefile().Dir = filepath.Dir(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = make.BuildContext(ctx, "all", "out")
+	var canceled *builder.CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a *builder.CanceledError, got %v", err)
+	}
+	if make.IsBuilt("out") {
+		t.Error("Expected the canceled build not to go on with out")
+	}
+
+	// The Make can still build afterwards
+	if err := make.BuildContext(context.Background(), "out"); err != nil {
+		t.Fatalf("BuildContext failed: %v", err)
+	}
+}
+
+func TestJobserverClosedOnError(t *testing.T) {
+	fifos := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(os.TempDir(), fmt.Sprintf("GMfifo%d*", os.Getpid())))
+		return matches
+	}
+	before := len(fifos())
+
+	// Reading the Makefile fails
+	path := writeMakefile(t, "$(error broken)\n")
+	if _, err := New(path, WithJobs(4), WithJobserverStyle(builder.JobserverFifo)); err == nil {
+		t.Fatal("Expected New to fail")
+	}
+
+	// Defining $(CURDIR) fails, before the Makefile is read
+	oldwd, _ := os.Getwd()
+	defer os.Chdir(oldwd)
+	gone := filepath.Join(t.TempDir(), "gone")
+	os.Mkdir(gone, 0755)
+	os.Chdir(gone)
+	os.Remove(gone)
+	if _, err := New(path, WithJobs(4), WithJobserverStyle(builder.JobserverFifo)); err == nil {
+		t.Fatal("Expected New to fail in a removed directory")
+	}
+	if after := fifos(); len(after) != before {
+		t.Errorf("Expected the jobserver fifos to be removed, found %q", after)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	path := writeMakefile(t, "OUT := $(shell echo parsed >&2)\nall:\n\techo hello\n")
+
+	var output, stderr strings.Builder
+	make, err := New(path, WithOutput(&output, &stderr))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := make.Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if want := "Building target: all\n\techo hello\nhello\n"; output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+	// The errors of $(shell) go to the same stderr as those of recipes
+	if stderr.String() != "parsed\n" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "parsed\n")
+	}
+}
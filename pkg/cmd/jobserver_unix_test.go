@@ -0,0 +1,55 @@
+//go:build unix
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestJoinedJobserver(t *testing.T) {
+	// A parent make passes its jobserver as descriptors other than 3,4,
+	// as GNU make may
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	w.Write([]byte("+++"))
+	rfd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wfd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the recursive line gets the jobserver, as descriptors 3,4, and
+	// the make it runs is told so
+	path := writeMakefile(t, fmt.Sprintf("all:\n"+
+		"\t[ ! -e /dev/fd/%d ] && [ ! -e /dev/fd/%d ]\n"+
+		"\t+[ -p /dev/fd/3 ] && [ -p /dev/fd/4 ] && echo \"$$MAKEFLAGS\" > flags.txt\n", rfd, wfd))
+
+	make, err := New(path, WithMakeFlags(fmt.Sprintf("-j4 --jobserver-auth=%d,%d", rfd, wfd)))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer make.Close()
+	make.Makefile().Dir = filepath.Dir(path)
+	if make.jobserver == nil {
+		t.Fatal("Expected the make to join the jobserver")
+	}
+	if err := make.Build("all"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	data, err := os.ReadFile(make.Makefile().Path("flags.txt"))
+	if err != nil || strings.TrimSpace(string(data)) != "-j4 --jobserver-auth=3,4" {
+		t.Errorf("MAKEFLAGS of the sub-make = %q, %v", data, err)
+	}
+}
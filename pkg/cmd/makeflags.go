@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// MakeFlags is the decoded form of the MAKEFLAGS variable, through which
+// make passes its options and command line variables to sub-makes.
+type MakeFlags struct {
+	// Letters are the single-letter options, such as "e" or "k"
+	Letters string
+
+	// Options are long options, such as "--jobserver-auth=3,4", and the
+	// job limit, such as "-j4"
+	Options []string
+
+	// Variables are command line assignments, such as "CC=clang"
+	Variables []string
+}
+
+// ParseMakeFlags decodes a MAKEFLAGS value. It accepts the form GNU make
+// produces ("ek -- CC=clang") as well as options written with dashes.
+// Backslashes escape spaces and backslashes within words.
+func ParseMakeFlags(value string) MakeFlags {
+	var flags MakeFlags
+	words := splitEscaped(value)
+	for i, word := range words {
+		switch {
+		case word == "--":
+			flags.Variables = append(flags.Variables, words[i+1:]...)
+			return flags
+		case strings.HasPrefix(word, "--"), strings.HasPrefix(word, "-j"):
+			flags.Options = append(flags.Options, word)
+		case strings.Contains(word, "="):
+			flags.Variables = append(flags.Variables, word)
+		case strings.HasPrefix(word, "-"):
+			flags.Letters += word[1:]
+		case i == 0:
+			flags.Letters += word
+		}
+	}
+	return flags
+}
+
+// String encodes the flags in the form GNU make uses for MAKEFLAGS.
+func (f MakeFlags) String() string {
+	var words []string
+	if f.Letters != "" {
+		words = append(words, f.Letters)
+	}
+	words = append(words, f.Options...)
+	if len(f.Variables) > 0 {
+		words = append(words, "--")
+		for _, variable := range f.Variables {
+			words = append(words, escapeWord(variable))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// commandLineVariables returns the command line assignments of mf in the
+// NAME=value form used by MAKEFLAGS, sorted by name.
+func commandLineVariables(mf *types.Makefile) []string {
+	var assignments []string
+	for name, v := range mf.Variables {
+		if v.Origin == types.OriginCommandLine {
+			op := types.AssignRecursive
+			if v.Flavor == types.Simple {
+				op = types.AssignSimple
+			}
+			assignments = append(assignments, name+op+v.Value)
+		}
+	}
+	sort.Strings(assignments)
+	return assignments
+}
+
+// splitEscaped splits value on unescaped whitespace, removing the
+// backslashes that escape spaces and other backslashes.
+func splitEscaped(value string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value) && (value[i+1] == ' ' || value[i+1] == '\t' || value[i+1] == '\\'):
+			word.WriteByte(value[i+1])
+			inWord = true
+			i++
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// escapeWord escapes backslashes and whitespace so that splitEscaped
+// recovers the word unchanged.
+func escapeWord(word string) string {
+	var buf strings.Builder
+	for i := 0; i < len(word); i++ {
+		switch word[i] {
+		case '\\', ' ', '\t':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(word[i])
+	}
+	return buf.String()
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+func TestParseMakeFlags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  MakeFlags
+	}{
+		{"", MakeFlags{}},
+		{"e", MakeFlags{Letters: "e"}},
+		{"ek -- CC=clang", MakeFlags{Letters: "ek", Variables: []string{"CC=clang"}}},
+		{"-e -k", MakeFlags{Letters: "ek"}},
+		{"e --jobserver-auth=3,4", MakeFlags{Letters: "e", Options: []string{"--jobserver-auth=3,4"}}},
+		{" -- FLAGS=-O2\\ -g", MakeFlags{Variables: []string{"FLAGS=-O2 -g"}}},
+		{"CC=gcc", MakeFlags{Variables: []string{"CC=gcc"}}},
+	}
+
+	for _, test := range tests {
+		got := ParseMakeFlags(test.value)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseMakeFlags(%q) = %+v, want %+v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestMakeFlagsRoundTrip(t *testing.T) {
+	flags := MakeFlags{Letters: "e", Variables: []string{"CC=clang", `FLAGS:=-O2 -DX=a\b`}}
+	value := flags.String()
+	if value != `e -- CC=clang FLAGS:=-O2\ -DX=a\\b` {
+		t.Errorf("String() = %q", value)
+	}
+	if got := ParseMakeFlags(value); !reflect.DeepEqual(got, flags) {
+		t.Errorf("ParseMakeFlags(%q) = %+v, want %+v", value, got, flags)
+	}
+}
+
+func TestCommandLineVariables(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Makefile")
+	content := "CC = gcc\nBUILD := debug\nall:\n\t@true\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	make, err := New(path,
+		WithVariable("CC", "clang"),
+		WithAssignments("BUILD:=release"),
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mf := make.Makefile()
+	tests := []struct {
+		name   string
+		value  string
+		origin types.Origin
+	}{
+		{"CC", "clang", types.OriginCommandLine},
+		{"BUILD", "release", types.OriginCommandLine},
+		{"MAKEFLAGS", "-- BUILD:=release CC=clang", types.OriginFile},
+	}
+	for _, test := range tests {
+		if got := mf.ExpandVariables("$(" + test.name + ")"); got != test.value {
+			t.Errorf("$(%s) = %q, want %q", test.name, got, test.value)
+		}
+		if got := mf.Origin(test.name); got != test.origin {
+			t.Errorf("origin of %s = %v, want %v", test.name, got, test.origin)
+		}
+	}
+}
+
+func TestWithMakeFlags(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Makefile")
+	if err := os.WriteFile(path, []byte("CC = gcc\nall:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	make, err := New(path, WithMakeFlags("e -- CC=clang"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	mf := make.Makefile()
+	if !mf.EnvironmentOverrides {
+		t.Error("expected environment overrides from MAKEFLAGS")
+	}
+	if got := mf.ExpandVariables("$(CC)"); got != "clang" {
+		t.Errorf("$(CC) = %q, want %q", got, "clang")
+	}
+	if got := mf.GetVariable("MAKEFLAGS"); got != "e -- CC=clang" {
+		t.Errorf("MAKEFLAGS = %q, want %q", got, "e -- CC=clang")
+	}
+}
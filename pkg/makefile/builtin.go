@@ -0,0 +1,76 @@
+package makefile
+
+import (
+	"strings"
+
+	"github.com/5l0p/go-make/pkg/types"
+)
+
+// builtinRules is the catalogue of built-in implicit rules, written as the
+// pattern rules GNU make derives from its suffix rules. The recipes use the
+// built-in variables in types.DefaultVariables, so they follow CC, CFLAGS
+// and friends as set by the Makefile.
+const builtinRules = `
+# Programs linked from a single source or object file. The order of each
+# group follows GNU make's default suffix list, so that an object file is
+# preferred to a source file of the same name.
+%: %.o
+	$(LINK.o) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.c
+	$(LINK.c) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.cc
+	$(LINK.cc) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.C
+	$(LINK.C) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.cpp
+	$(LINK.cpp) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.m
+	$(LINK.m) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.s
+	$(LINK.s) $^ $(LOADLIBES) $(LDLIBS) -o $@
+%: %.S
+	$(LINK.S) $^ $(LOADLIBES) $(LDLIBS) -o $@
+
+# Object files compiled from C, C++, Objective-C and assembler
+%.o: %.c
+	$(COMPILE.c) $(OUTPUT_OPTION) $<
+%.o: %.cc
+	$(COMPILE.cc) $(OUTPUT_OPTION) $<
+%.o: %.C
+	$(COMPILE.C) $(OUTPUT_OPTION) $<
+%.o: %.cpp
+	$(COMPILE.cpp) $(OUTPUT_OPTION) $<
+%.o: %.m
+	$(COMPILE.m) $(OUTPUT_OPTION) $<
+%.o: %.s
+	$(COMPILE.s) -o $@ $<
+%.o: %.S
+	$(COMPILE.S) -o $@ $<
+
+# Preprocessed assembler
+%.s: %.S
+	$(PREPROCESS.S) $< > $@
+
+# C sources generated by yacc and lex
+%.c: %.y
+	$(YACC.y) $<
+	mv -f y.tab.c $@
+%.c: %.l
+	@$(RM) $@
+	$(LEX.l) $< > $@
+
+# Archive members
+(%): %
+	$(AR) $(ARFLAGS) $@ $<
+`
+
+// DefineBuiltinRules adds the built-in implicit rules to makefile's
+// BuiltinRules. They are searched after the Makefile's own pattern rules.
+func DefineBuiltinRules(makefile *types.Makefile) error {
+	catalogue := types.NewMakefile()
+	if err := Parse(catalogue, strings.NewReader(builtinRules), ""); err != nil {
+		return err
+	}
+	makefile.BuiltinRules = append(makefile.BuiltinRules, catalogue.PatternRules...)
+	return nil
+}
@@ -3,7 +3,10 @@ package makefile
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/5l0p/go-make/pkg/types"
@@ -12,7 +15,13 @@ import (
 // ParseMakefile parses a Makefile from the given filename and returns a Makefile struct.
 // It supports:
 //   - Target definitions with dependencies (target: dep1 dep2)
+//   - Order-only prerequisites (target: dep1 | dir)
+//   - Pattern rules (%.o: %.c), and cancelling them with an empty recipe
 //   - Commands indented with tabs
+//   - Variable assignments with =, :=, ::=, ?=, += and !=
+//   - Multi-line variables with define/endef
+//   - export and unexport directives, and .EXPORT_ALL_VARIABLES
+//   - Target-specific and pattern-specific variables (target: VAR = value)
 //   - Comments (lines starting with #)
 //   - Empty lines (ignored)
 //
@@ -23,92 +32,468 @@ import (
 //   }
 //   fmt.Printf("First target: %s\n", makefile.FirstRule)
 func ParseMakefile(filename string) (*types.Makefile, error) {
-	file, err := os.Open(filename)
-	if err != nil {
+	makefile := types.NewMakefile()
+	if err := ParseFile(makefile, filename); err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	return ParseMakefileFromReader(file)
+	return makefile, nil
 }
 
 // ParseMakefileFromReader parses a Makefile from an io.Reader.
 // This is useful for testing or when the Makefile content comes from a source
 // other than a file on disk.
-func ParseMakefileFromReader(reader *os.File) (*types.Makefile, error) {
+func ParseMakefileFromReader(reader io.Reader) (*types.Makefile, error) {
 	makefile := types.NewMakefile()
+	if err := Parse(makefile, reader, ""); err != nil {
+		return nil, err
+	}
+	return makefile, nil
+}
+
+// ParseFile parses the named file into an existing Makefile. This lets
+// callers configure the Makefile, for example its Diagnostics writer,
+// before any of its text is expanded.
+func ParseFile(makefile *types.Makefile, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return Parse(makefile, file, filename)
+}
+
+// Parse reads makefile text from reader and adds its rules and variables to
+// an existing Makefile. The name is used in error positions and may be empty.
+// Parse also installs the Makefile's Evaluator, so that $(eval) feeds text
+// back into the parser.
+func Parse(makefile *types.Makefile, reader io.Reader, name string) error {
+	if makefile.Evaluator == nil {
+		makefile.Evaluator = func(text string) error {
+			return evaluate(makefile, text)
+		}
+	}
+
+	saved := makefile.Location
+	defer func() { makefile.Location = saved }()
+
+	p := &parser{makefile: makefile, pos: types.Position{File: name}}
+	if err := p.parse(reader, true); err != nil {
+		return err
+	}
+	p.finishRules()
+	return nil
+}
+
+// evaluate parses text passed to $(eval). Every line of the text is
+// reported at the position of the eval call.
+func evaluate(makefile *types.Makefile, text string) error {
+	p := &parser{makefile: makefile, pos: makefile.Location}
+	if err := p.parse(strings.NewReader(text), false); err != nil {
+		return err
+	}
+	p.finishRules()
+	return nil
+}
+
+// parser holds the state of a single parse.
+type parser struct {
+	makefile     *types.Makefile
+	currentRules []*types.Rule
+	define       *definition
+	pos          types.Position
+}
+
+// definition collects the body of a define directive until its endef.
+type definition struct {
+	name  string
+	op    string
+	mods  modifiers
+	lines []string
+	depth int
+	pos   types.Position
+}
+
+// modifiers are the words that may precede a variable assignment or a
+// define: override gives it the override origin, export exports it, and
+// private keeps it from being inherited by targets or prerequisites.
+type modifiers struct {
+	override bool
+	export   bool
+	private  bool
+}
+
+// parse reads every line from reader. When countLines is false, all lines
+// keep the parser's starting position.
+func (p *parser) parse(reader io.Reader, countLines bool) error {
 	scanner := bufio.NewScanner(reader)
-	var currentRule *types.Rule
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		
-		// Skip empty lines and comments
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
-			continue
+		if countLines {
+			p.pos.Line++
+		}
+		p.makefile.Location = p.pos
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return types.ErrorAt(p.pos, err)
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
 
-		// Commands start with a tab
-		if strings.HasPrefix(line, "\t") {
-			if currentRule != nil {
-				command := strings.TrimPrefix(line, "\t")
-				// Expand variables in commands
-				expandedCommand := makefile.ExpandVariables(command)
-				currentRule.Commands = append(currentRule.Commands, expandedCommand)
-			}
-		} else if name, value, isAssignment := parseVariableAssignment(line); isAssignment {
-			// Variable assignment: VAR = value
-			// Expand variables in the value
-			expandedValue := makefile.ExpandVariables(value)
-			makefile.SetVariable(name, expandedValue)
-		} else if strings.Contains(line, ":") {
-			// Target definition: target: dependency1 dependency2
-			parts := strings.SplitN(line, ":", 2)
-			target := strings.TrimSpace(parts[0])
-			deps := strings.Fields(strings.TrimSpace(parts[1]))
-			
-			// Expand variables in target name and dependencies
-			expandedTarget := makefile.ExpandVariables(target)
-			expandedDeps := make([]string, len(deps))
-			for i, dep := range deps {
-				expandedDeps[i] = makefile.ExpandVariables(dep)
-			}
-			
+	if p.define != nil {
+		return types.ErrorAt(p.define.pos, fmt.Errorf("missing 'endef', unterminated 'define'"))
+	}
+	return nil
+}
+
+// parseLine parses one line of makefile text.
+func (p *parser) parseLine(line string) error {
+	if p.define != nil {
+		return p.parseDefineLine(line)
+	}
+
+	// Skip empty lines and comments
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+
+	// Commands start with a tab
+	if strings.HasPrefix(line, "\t") && len(p.currentRules) > 0 {
+		// Commands are expanded when they run, once the
+		// automatic variables of the target are known
+		command := strings.TrimPrefix(line, "\t")
+		for _, rule := range p.currentRules {
+			rule.Commands = append(rule.Commands, command)
+			rule.CommandPos = append(rule.CommandPos, p.pos)
+		}
+		return nil
+	}
+
+	mods, rest := parseModifiers(line)
+	word, args := cutWord(rest)
+	switch {
+	case word == "define":
+		name, op, ok := parseDefine(args)
+		if !ok {
+			return fmt.Errorf("empty variable name")
+		}
+		p.define = &definition{name: name, op: op, mods: mods, pos: p.pos}
+		return nil
+	case word == "undefine":
+		return p.parseUndefine(mods, args)
+	case word == "unexport" && mods == (modifiers{}):
+		return p.parseExport(false, args)
+	}
+
+	if name, op, value, isAssignment := types.ParseAssignment(rest); isAssignment {
+		// Variable assignment: VAR = value
+		return p.assign(mods, name, op, value)
+	}
+
+	if mods.export && !mods.override && !mods.private {
+		return p.parseExport(true, rest)
+	}
+
+	return p.parseRule(line)
+}
+
+// listTargets are the special targets whose prerequisites are lists of
+// files given special treatment, which accumulate over the lines that name
+// the target.
+var listTargets = map[string]bool{
+	".PHONY":     true,
+	".PRECIOUS":  true,
+	".SECONDARY": true,
+}
+
+// parseRule parses a rule line. The line is expanded before it is split, so a
+// single reference may produce several prerequisites, and a line that expands
+// to nothing (such as a bare $(eval ...)) is ignored.
+func (p *parser) parseRule(line string) error {
+	if targets, rest, ok := types.SplitRule(line); ok {
+		if handled, err := p.parseTargetVariable(targets, rest); handled || err != nil {
+			return err
+		}
+	}
+
+	expanded, err := p.makefile.Expand(line)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(expanded) == "" {
+		return nil
+	}
+
+	// Target definition: target: dependency1 dependency2
+	parts := strings.SplitN(expanded, ":", 2)
+	if len(parts) != 2 {
+		// Lines that are neither rules nor assignments, such as
+		// unsupported directives, are ignored
+		return nil
+	}
+	target := strings.TrimSpace(parts[0])
+	p.finishRules()
+
+	// Prerequisites after a '|' are order-only
+	prereqs, orderOnly, _ := strings.Cut(parts[1], "|")
+
+	// An empty .SUFFIXES list disables the built-in rules
+	if target == ".SUFFIXES" && strings.TrimSpace(parts[1]) == "" {
+		p.makefile.BuiltinRules = nil
+		return nil
+	}
+	if target == ".EXPORT_ALL_VARIABLES" {
+		p.makefile.ExportAll = true
+		return nil
+	}
+
+	if types.IsPattern(target) {
+		// Each target pattern of a pattern rule gets its own rule
+		for _, pattern := range strings.Fields(target) {
 			rule := &types.Rule{
-				Target:       expandedTarget,
-				Dependencies: expandedDeps,
+				Target:       pattern,
+				Dependencies: strings.Fields(prereqs),
+				OrderOnly:    strings.Fields(orderOnly),
 				Commands:     []string{},
+				Pos:          p.pos,
 			}
-			
-			// Set the first rule as the default target
-			if makefile.FirstRule == "" {
-				makefile.FirstRule = expandedTarget
-			}
-			
-			makefile.Rules[expandedTarget] = rule
-			currentRule = rule
+			p.makefile.PatternRules = append(p.makefile.PatternRules, rule)
+			p.currentRules = append(p.currentRules, rule)
 		}
+		return nil
 	}
 
-	return makefile, scanner.Err()
+	// A special target listed again, as in a second .PHONY line, gets the
+	// new prerequisites added to those it has
+	if existing, ok := p.makefile.Rules[target]; ok && listTargets[target] {
+		existing.Dependencies = append(existing.Dependencies, strings.Fields(prereqs)...)
+		p.currentRules = []*types.Rule{existing}
+		return nil
+	}
+
+	rule := &types.Rule{
+		Target:       target,
+		Dependencies: strings.Fields(prereqs),
+		OrderOnly:    strings.Fields(orderOnly),
+		Commands:     []string{},
+		Pos:          p.pos,
+	}
+
+	// Set the first rule as the default target. Like GNU make, targets
+	// starting with '.' are skipped unless they contain a '/'.
+	if p.makefile.FirstRule == "" && (!strings.HasPrefix(target, ".") || strings.Contains(target, "/")) {
+		p.makefile.FirstRule = target
+	}
+
+	p.makefile.Rules[target] = rule
+	p.currentRules = []*types.Rule{rule}
+	return nil
 }
 
-// parseVariableAssignment parses a variable assignment line like "VAR = value"
-// Returns the variable name, value, and whether it was a valid assignment.
-func parseVariableAssignment(line string) (name, value string, isAssignment bool) {
-	// Look for = sign (supporting spaces around it)
-	parts := strings.SplitN(line, "=", 2)
-	if len(parts) != 2 {
-		return "", "", false
+// parseTargetVariable parses the part of a rule line after the colon as a
+// target-specific variable assignment, optionally preceded by export,
+// override or private. It reports whether the line was such an assignment.
+func (p *parser) parseTargetVariable(targets, rest string) (bool, error) {
+	mods, rest := parseModifiers(rest)
+	name, op, value, ok := types.ParseAssignment(rest)
+	if !ok {
+		return false, nil
+	}
+
+	p.finishRules()
+	expanded, err := p.makefile.Expand(targets)
+	if err != nil {
+		return true, err
+	}
+	for _, target := range strings.Fields(expanded) {
+		tv := &types.TargetVariable{
+			Target:  target,
+			Name:    name,
+			Op:      op,
+			Value:   value,
+			Origin:  mods.origin(),
+			Export:  mods.export,
+			Private: mods.private,
+			Pos:     p.pos,
+		}
+		if err := p.makefile.AddTargetVariable(tv); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// assign performs a variable assignment written with mods.
+func (p *parser) assign(mods modifiers, name, op, value string) error {
+	if err := p.makefile.AssignOrigin(name, op, value, mods.origin()); err != nil {
+		return err
+	}
+	if mods.export {
+		p.makefile.Export(name, true)
+	}
+	if mods.private {
+		p.makefile.MarkPrivate(name, mods.origin())
+	}
+	return nil
+}
+
+// parseUndefine handles "undefine NAME...", which removes each named
+// variable unless it was defined with an origin that takes precedence.
+func (p *parser) parseUndefine(mods modifiers, args string) error {
+	names, err := p.makefile.Expand(args)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(names) == "" {
+		return fmt.Errorf("empty variable name")
+	}
+	for _, name := range strings.Fields(names) {
+		p.makefile.Undefine(name, mods.origin())
+	}
+	return nil
+}
+
+// parseExport handles "export" and "unexport" followed by variable names.
+// Without names they turn exporting of all variables on or off.
+func (p *parser) parseExport(export bool, args string) error {
+	if strings.TrimSpace(args) == "" {
+		p.makefile.ExportAll = export
+		return nil
+	}
+
+	names, err := p.makefile.Expand(args)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(names) {
+		p.makefile.Export(name, export)
+	}
+	return nil
+}
+
+// parseModifiers strips the override, export and private words from the
+// start of text. A word followed by an assignment operator is a variable
+// name rather than a modifier.
+func parseModifiers(text string) (mods modifiers, rest string) {
+	rest = text
+	if strings.HasPrefix(rest, "\t") {
+		return mods, rest
+	}
+	for {
+		word, after := cutWord(rest)
+		if name, _, _, ok := types.ParseAssignment(rest); ok && name == word {
+			return mods, rest
+		}
+		switch word {
+		case "override":
+			mods.override = true
+		case "export":
+			mods.export = true
+		case "private":
+			mods.private = true
+		default:
+			return mods, rest
+		}
+		rest = after
+	}
+}
+
+// origin returns the origin of a variable defined with the modifiers.
+func (mods modifiers) origin() types.Origin {
+	if mods.override {
+		return types.OriginOverride
+	}
+	return types.OriginFile
+}
+
+// cutWord returns the first blank-separated word of text and the rest of
+// the text with surrounding blanks removed.
+func cutWord(text string) (word, rest string) {
+	text = strings.TrimLeft(text, " \t")
+	end := strings.IndexAny(text, " \t")
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], strings.TrimSpace(text[end:])
+}
+
+// finishRules completes the rules whose recipe was being read. A pattern
+// rule without a recipe cancels any earlier pattern rule, built-in or not,
+// with the same target and prerequisites.
+func (p *parser) finishRules() {
+	for _, rule := range p.currentRules {
+		if types.IsPattern(rule.Target) && len(rule.Commands) == 0 {
+			p.makefile.PatternRules = cancelRule(p.makefile.PatternRules, rule)
+			p.makefile.BuiltinRules = cancelRule(p.makefile.BuiltinRules, rule)
+		}
 	}
+	p.currentRules = nil
+}
 
-	name = strings.TrimSpace(parts[0])
-	value = strings.TrimSpace(parts[1])
+// cancelRule returns rules without those that have the same target and
+// prerequisites as cancel.
+func cancelRule(rules []*types.Rule, cancel *types.Rule) []*types.Rule {
+	var kept []*types.Rule
+	for _, rule := range rules {
+		if rule.Target != cancel.Target || !slices.Equal(rule.Dependencies, cancel.Dependencies) {
+			kept = append(kept, rule)
+		}
+	}
+	return kept
+}
+
+// parseDefineLine collects a line of a define body, completing the
+// definition when the matching endef is reached.
+func (p *parser) parseDefineLine(line string) error {
+	d := p.define
+	switch directive(line) {
+	case "define":
+		d.depth++
+	case "endef":
+		if d.depth == 0 {
+			p.define = nil
+			// Expansion happens relative to the define line
+			p.makefile.Location = d.pos
+			return types.ErrorAt(d.pos, p.assign(d.mods, d.name, d.op, strings.Join(d.lines, "\n")))
+		}
+		d.depth--
+	}
+	d.lines = append(d.lines, line)
+	return nil
+}
 
-	// Variable names should be valid identifiers (letters, digits, underscore)
-	if name == "" || strings.ContainsAny(name, " \t:") {
+// parseDefine parses the text after "define": a variable name optionally
+// followed by an assignment operator.
+func parseDefine(args string) (name, op string, ok bool) {
+	rest := strings.TrimSpace(args)
+
+	op = types.AssignRecursive
+	for _, candidate := range []string{types.AssignPosix, types.AssignSimple, types.AssignConditional, types.AssignAppend, types.AssignShell, types.AssignRecursive} {
+		if strings.HasSuffix(rest, candidate) {
+			op = candidate
+			rest = strings.TrimSpace(strings.TrimSuffix(rest, candidate))
+			break
+		}
+	}
+
+	if rest == "" {
 		return "", "", false
 	}
+	return rest, op, true
+}
 
-	return name, value, true
-}
\ No newline at end of file
+// directive returns "define" or "endef" if line starts a or ends a define,
+// or "" otherwise. A define may be preceded by modifiers.
+func directive(line string) string {
+	if strings.HasPrefix(line, "\t") {
+		return ""
+	}
+	_, rest := parseModifiers(line)
+	word, _ := cutWord(rest)
+	switch word {
+	case "define", "endef":
+		return word
+	}
+	return ""
+}
@@ -1,8 +1,10 @@
 package makefile
 
 import (
+	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/5l0p/go-make/pkg/types"
@@ -154,4 +156,335 @@ func TestParseEmptyMakefile(t *testing.T) {
 	if len(makefile.Rules) != 0 {
 		t.Errorf("Expected 0 rules for empty makefile, got %d", len(makefile.Rules))
 	}
-}
\ No newline at end of file
+}
+
+func TestParseAssignmentFlavors(t *testing.T) {
+	testMakefile := `CC = gcc
+LAZY = $(CC) -c
+EAGER := $(CC) -c
+CC = clang
+CFLAGS ?= -O2
+CFLAGS ?= -O0
+CFLAGS += -Wall
+OBJS = a.o b.o
+
+all: $(OBJS:.o=.c)
+`
+
+	makefile, err := ParseMakefileFromReader(strings.NewReader(testMakefile))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"LAZY":   "clang -c",
+		"EAGER":  "gcc -c",
+		"CFLAGS": "-O2 -Wall",
+	}
+	for name, want := range expected {
+		if got, _ := makefile.Expand("$(" + name + ")"); got != want {
+			t.Errorf("$(%s) = %q, want %q", name, got, want)
+		}
+	}
+
+	rule := makefile.GetTarget("all")
+	if rule == nil {
+		t.Fatal("Rule 'all' not found")
+	}
+	if !reflect.DeepEqual(rule.Dependencies, []string{"a.c", "b.c"}) {
+		t.Errorf("Expected dependencies [a.c b.c], got %v", rule.Dependencies)
+	}
+}
+
+func TestParseEvalTemplate(t *testing.T) {
+	testMakefile := `MODULES = net disk
+
+define module_tpl
+$(1)_OBJS = $(1)/main.o
+
+$(1): $$($(1)_OBJS)
+	echo building $$@
+endef
+
+$(foreach m,$(MODULES),$(eval $(call module_tpl,$m)))
+`
+
+	makefile, err := ParseMakefileFromReader(strings.NewReader(testMakefile))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	for _, module := range []string{"net", "disk"} {
+		rule := makefile.GetTarget(module)
+		if rule == nil {
+			t.Fatalf("Rule %q not generated by eval", module)
+		}
+		if want := []string{module + "/main.o"}; !reflect.DeepEqual(rule.Dependencies, want) {
+			t.Errorf("Rule %s: expected dependencies %v, got %v", module, want, rule.Dependencies)
+		}
+		if want := []string{"echo building $@"}; !reflect.DeepEqual(rule.Commands, want) {
+			t.Errorf("Rule %s: expected commands %v, got %v", module, want, rule.Commands)
+		}
+		if got := makefile.GetVariable(module + "_OBJS"); got != module+"/main.o" {
+			t.Errorf("Expected %s_OBJS to be set by eval, got %q", module, got)
+		}
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	testMakefile := `CC = gcc
+
+$(if $(TOOLCHAIN),,$(error TOOLCHAIN not set))
+`
+
+	tmpfile, err := os.CreateTemp("", "error-makefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(testMakefile)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = ParseMakefile(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected $(error) to abort parsing")
+	}
+
+	expected := tmpfile.Name() + ":3: *** TOOLCHAIN not set. Stop."
+	if err.Error() != expected {
+		t.Errorf("Error = %q, want %q", err.Error(), expected)
+	}
+
+	_, err = ParseMakefileFromReader(strings.NewReader("X := $(CC\n"))
+	if err == nil || !strings.Contains(err.Error(), "unterminated variable reference") {
+		t.Errorf("Expected unterminated variable reference error, got %v", err)
+	}
+}
+
+func TestParseRecordsPositions(t *testing.T) {
+	testMakefile := `# comment
+all: dep
+
+	echo one
+	echo two
+`
+
+	makefile, err := ParseMakefileFromReader(strings.NewReader(testMakefile))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	rule := makefile.GetTarget("all")
+	if rule.Pos.Line != 2 {
+		t.Errorf("Expected rule at line 2, got %d", rule.Pos.Line)
+	}
+	if rule.CommandPosition(0).Line != 4 || rule.CommandPosition(1).Line != 5 {
+		t.Errorf("Expected commands at lines 4 and 5, got %v", rule.CommandPos)
+	}
+}
+
+func TestParseOrderOnlyPrerequisites(t *testing.T) {
+	makefile, err := ParseMakefileFromReader(strings.NewReader("obj/a.o: a.c a.h | obj\n"))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	rule := makefile.GetTarget("obj/a.o")
+	if !reflect.DeepEqual(rule.Dependencies, []string{"a.c", "a.h"}) {
+		t.Errorf("Expected dependencies [a.c a.h], got %v", rule.Dependencies)
+	}
+	if !reflect.DeepEqual(rule.OrderOnly, []string{"obj"}) {
+		t.Errorf("Expected order-only prerequisites [obj], got %v", rule.OrderOnly)
+	}
+}
+
+func TestParsePatternRules(t *testing.T) {
+	content := `.PHONY: all
+%.o: %.c | obj
+	cc -c $< -o $@
+%.pdf %.ps: %.tex
+	tex $<
+%.x: %.y
+	yacc $<
+%.x: %.y
+all: app
+`
+	makefile, err := ParseMakefileFromReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	if makefile.FirstRule != "all" {
+		t.Errorf("Expected default target all, got %q", makefile.FirstRule)
+	}
+	if makefile.HasTarget("%.o") {
+		t.Error("Pattern rules should not be stored as explicit rules")
+	}
+
+	// The rule with an empty recipe cancels the %.x rule before it
+	var targets []string
+	for _, rule := range makefile.PatternRules {
+		targets = append(targets, rule.Target)
+	}
+	if !reflect.DeepEqual(targets, []string{"%.o", "%.pdf", "%.ps"}) {
+		t.Fatalf("Expected pattern rules [%%.o %%.pdf %%.ps], got %v", targets)
+	}
+
+	rule := makefile.PatternRules[0]
+	if !reflect.DeepEqual(rule.Dependencies, []string{"%.c"}) || !reflect.DeepEqual(rule.OrderOnly, []string{"obj"}) {
+		t.Errorf("Unexpected prerequisites %v | %v", rule.Dependencies, rule.OrderOnly)
+	}
+	if !reflect.DeepEqual(makefile.PatternRules[2].Commands, []string{"tex $<"}) {
+		t.Errorf("Each target pattern should get the recipe, got %v", makefile.PatternRules[2].Commands)
+	}
+}
+
+func TestBuiltinRules(t *testing.T) {
+	makefile := types.NewMakefile()
+	if err := DefineBuiltinRules(makefile); err != nil {
+		t.Fatalf("DefineBuiltinRules failed: %v", err)
+	}
+
+	has := func(target, prereq string) bool {
+		for _, rule := range makefile.BuiltinRules {
+			if rule.Target == target && len(rule.Dependencies) == 1 && rule.Dependencies[0] == prereq {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range [][2]string{{"%.o", "%.c"}, {"%.o", "%.cpp"}, {"%", "%.o"}, {"%.o", "%.s"}, {"(%)", "%"}, {"%.c", "%.y"}, {"%.c", "%.l"}} {
+		if !has(rule[0], rule[1]) {
+			t.Errorf("Expected a built-in rule %s: %s", rule[0], rule[1])
+		}
+	}
+
+	// A rule without a recipe cancels a built-in rule, and an empty
+	// .SUFFIXES removes them all
+	if err := Parse(makefile, strings.NewReader("%.o: %.c\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if has("%.o", "%.c") || !has("%.o", "%.cpp") {
+		t.Errorf("Expected %s to be cancelled and the other built-in rules kept", "%.o: %.c")
+	}
+	if err := Parse(makefile, strings.NewReader(".SUFFIXES:\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(makefile.BuiltinRules) != 0 {
+		t.Errorf("Expected .SUFFIXES: to remove the built-in rules, %d left", len(makefile.BuiltinRules))
+	}
+}
+
+func TestParseExportDirectives(t *testing.T) {
+	content := `NAMES = B C
+export A = 1
+export $(NAMES)
+unexport	D
+debug: export MODE := $(NAMES)
+debug obj/%.o: CFLAGS += -g
+`
+	makefile, err := ParseMakefileFromReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseMakefileFromReader failed: %v", err)
+	}
+
+	if got := makefile.GetVariable("A"); got != "1" {
+		t.Errorf("Expected export to assign A, got %q", got)
+	}
+	expected := map[string]bool{"A": true, "B": true, "C": true, "D": false}
+	if !reflect.DeepEqual(makefile.Exports, expected) {
+		t.Errorf("Exports = %v, want %v", makefile.Exports, expected)
+	}
+	if makefile.ExportAll {
+		t.Error("ExportAll should not be set")
+	}
+	if len(makefile.Rules) != 0 {
+		t.Errorf("Target-specific assignments should not define rules, got %v", makefile.Targets())
+	}
+
+	var got []string
+	for _, tv := range makefile.TargetVariables {
+		got = append(got, fmt.Sprintf("%s:%s%s%s export=%v", tv.Target, tv.Name, tv.Op, tv.Value, tv.Export))
+	}
+	want := []string{
+		"debug:MODE:=B C export=true",
+		"debug:CFLAGS+=-g export=false",
+		"obj/%.o:CFLAGS+=-g export=false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TargetVariables = %v, want %v", got, want)
+	}
+
+	for _, content := range []string{"export\n", ".EXPORT_ALL_VARIABLES:\n"} {
+		makefile, err := ParseMakefileFromReader(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("ParseMakefileFromReader failed: %v", err)
+		}
+		if !makefile.ExportAll {
+			t.Errorf("Expected %q to export all variables", content)
+		}
+	}
+}
+
+func TestParseOverrideUndefinePrivate(t *testing.T) {
+	t.Setenv("GOMAKE_TMP", "environment")
+	makefile := types.NewMakefile()
+	makefile.AssignOrigin("CFLAGS", types.AssignRecursive, "-O2", types.OriginCommandLine)
+	makefile.AssignOrigin("MODE", types.AssignRecursive, "release", types.OriginCommandLine)
+	content := `CFLAGS = -O0
+override CFLAGS += -Werror
+MODE = debug
+TMP = scratch
+undefine TMP GOMAKE_TMP
+override = plain
+private export SECRET := hidden
+override define BANNER
+define INNER
+endef
+built by go-make
+endef
+lib: private override LDFLAGS = -shared
+`
+	if err := Parse(makefile, strings.NewReader(content), ""); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		origin types.Origin
+	}{
+		{"CFLAGS", "-O2 -Werror", types.OriginOverride},
+		{"MODE", "release", types.OriginCommandLine},
+		{"TMP", "", types.OriginUndefined},
+		{"GOMAKE_TMP", "", types.OriginUndefined},
+		{"override", "plain", types.OriginFile},
+		{"SECRET", "hidden", types.OriginFile},
+		{"BANNER", "define INNER\nendef\nbuilt by go-make", types.OriginOverride},
+	}
+	for _, tt := range tests {
+		value, err := makefile.Expand("$(" + tt.name + ")")
+		if err != nil {
+			t.Fatalf("Expand(%s) failed: %v", tt.name, err)
+		}
+		if value != tt.value {
+			t.Errorf("$(%s) = %q, want %q", tt.name, value, tt.value)
+		}
+		if origin := makefile.Origin(tt.name); origin != tt.origin {
+			t.Errorf("origin of %s = %v, want %v", tt.name, origin, tt.origin)
+		}
+	}
+
+	if v := makefile.Variable("SECRET"); v == nil || !v.Private || !makefile.Exports["SECRET"] {
+		t.Errorf("Expected SECRET to be private and exported, got %+v", v)
+	}
+	if len(makefile.TargetVariables) != 1 {
+		t.Fatalf("Expected one target variable, got %d", len(makefile.TargetVariables))
+	}
+	if tv := makefile.TargetVariables[0]; !tv.Private || tv.Origin != types.OriginOverride || tv.Name != "LDFLAGS" {
+		t.Errorf("Unexpected target variable %+v", tv)
+	}
+}
@@ -0,0 +1,81 @@
+package types
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PrintDatabase writes the variables and rules of the Makefile to w in the
+// style of GNU make's -p option: each variable preceded by its origin, the
+// implicit rules, and then the explicit rules, each with its recipe.
+func (m *Makefile) PrintDatabase(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "# go-make data base, compatible with GNU Make %s\n", MakeVersion)
+
+	fmt.Fprintf(out, "\n# Variables\n")
+	names := make([]string, 0, len(m.Variables))
+	for name := range m.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := m.Variables[name]
+		op := AssignRecursive
+		if v.Flavor == Simple {
+			op = AssignSimple
+		}
+		if strings.Contains(v.Value, "\n") {
+			fmt.Fprintf(out, "\n# %s\ndefine %s %s\n%s\nendef\n", v.Origin, name, op, v.Value)
+			continue
+		}
+		fmt.Fprintf(out, "\n# %s\n%s %s %s\n", v.Origin, name, op, v.Value)
+	}
+
+	fmt.Fprintf(out, "\n# Implicit Rules\n")
+	for _, rule := range m.PatternRules {
+		printRule(out, rule)
+	}
+	for _, rule := range m.BuiltinRules {
+		printRule(out, rule)
+	}
+
+	fmt.Fprintf(out, "\n# Files\n")
+	targets := m.Targets()
+	sort.Strings(targets)
+	for _, target := range targets {
+		printRule(out, m.Rules[target])
+	}
+
+	return out.Flush()
+}
+
+// printRule writes a rule and its recipe, noting where the recipe came from.
+func printRule(w io.Writer, rule *Rule) {
+	fmt.Fprintf(w, "\n%s:", rule.Target)
+	for _, dep := range rule.Dependencies {
+		fmt.Fprintf(w, " %s", dep)
+	}
+	if len(rule.OrderOnly) > 0 {
+		fmt.Fprintf(w, " |")
+		for _, dep := range rule.OrderOnly {
+			fmt.Fprintf(w, " %s", dep)
+		}
+	}
+	fmt.Fprintln(w)
+
+	if len(rule.Commands) == 0 {
+		return
+	}
+	if rule.Pos.File == "" {
+		fmt.Fprintf(w, "#  recipe to execute (built-in):\n")
+	} else {
+		fmt.Fprintf(w, "#  recipe to execute (from '%s', line %d):\n", rule.Pos.File, rule.Pos.Line)
+	}
+	for _, command := range rule.Commands {
+		fmt.Fprintf(w, "\t%s\n", command)
+	}
+}
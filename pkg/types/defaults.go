@@ -0,0 +1,83 @@
+package types
+
+// MakeVersion is the version of GNU make whose behaviour go-make follows.
+// It is the value of $(MAKE_VERSION).
+const MakeVersion = "4.4"
+
+// Features lists the optional features go-make supports, as reported by
+// $(.FEATURES).
+var Features = []string{"order-only", "target-specific", "undefine", "jobserver", "jobserver-fifo"}
+
+// DefaultVariables are GNU make's built-in variables, in the order make -p
+// prints them. They are defined with OriginDefault, so the environment and
+// the Makefile both take precedence over them. Flag variables such as CFLAGS
+// are referenced but, as in GNU make, left undefined, so that assignments
+// such as "CFLAGS ?= -O2" still take effect.
+var DefaultVariables = []Variable{
+	// Programs
+	{Name: "AR", Value: "ar"},
+	{Name: "ARFLAGS", Value: "rv"},
+	{Name: "AS", Value: "as"},
+	{Name: "CC", Value: "cc"},
+	{Name: "CXX", Value: "g++"},
+	{Name: "CPP", Value: "$(CC) -E"},
+	{Name: "FC", Value: "f77"},
+	{Name: "F77", Value: "$(FC)"},
+	{Name: "F77FLAGS", Value: "$(FFLAGS)"},
+	{Name: "PC", Value: "pc"},
+	{Name: "OBJC", Value: "cc"},
+	{Name: "LD", Value: "ld"},
+	{Name: "LEX", Value: "lex"},
+	{Name: "YACC", Value: "yacc"},
+	{Name: "LINT", Value: "lint"},
+	{Name: "M2C", Value: "m2c"},
+	{Name: "CO", Value: "co"},
+	{Name: "GET", Value: "get"},
+	{Name: "MAKEINFO", Value: "makeinfo"},
+	{Name: "TEX", Value: "tex"},
+	{Name: "TEXI2DVI", Value: "texi2dvi"},
+	{Name: "WEAVE", Value: "weave"},
+	{Name: "CWEAVE", Value: "cweave"},
+	{Name: "TANGLE", Value: "tangle"},
+	{Name: "CTANGLE", Value: "ctangle"},
+	{Name: "RM", Value: "rm -f"},
+
+	// Commands used by the built-in rules
+	{Name: "OUTPUT_OPTION", Value: "-o $@"},
+	{Name: "COMPILE.c", Value: "$(CC) $(CFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.c", Value: "$(CC) $(CFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "LINK.o", Value: "$(CC) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "COMPILE.cc", Value: "$(CXX) $(CXXFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "COMPILE.C", Value: "$(COMPILE.cc)"},
+	{Name: "COMPILE.cpp", Value: "$(COMPILE.cc)"},
+	{Name: "LINK.cc", Value: "$(CXX) $(CXXFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "LINK.C", Value: "$(LINK.cc)"},
+	{Name: "LINK.cpp", Value: "$(LINK.cc)"},
+	{Name: "COMPILE.m", Value: "$(OBJC) $(OBJCFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.m", Value: "$(OBJC) $(OBJCFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "COMPILE.s", Value: "$(AS) $(ASFLAGS) $(TARGET_MACH)"},
+	{Name: "LINK.s", Value: "$(CC) $(ASFLAGS) $(LDFLAGS) $(TARGET_MACH)"},
+	{Name: "COMPILE.S", Value: "$(CC) $(ASFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.S", Value: "$(CC) $(ASFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "PREPROCESS.S", Value: "$(CPP) $(CPPFLAGS)"},
+	{Name: "COMPILE.f", Value: "$(FC) $(FFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.f", Value: "$(FC) $(FFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "COMPILE.F", Value: "$(FC) $(FFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.F", Value: "$(FC) $(FFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "COMPILE.p", Value: "$(PC) $(PFLAGS) $(CPPFLAGS) $(TARGET_ARCH) -c"},
+	{Name: "LINK.p", Value: "$(PC) $(PFLAGS) $(CPPFLAGS) $(LDFLAGS) $(TARGET_ARCH)"},
+	{Name: "LEX.l", Value: "$(LEX) $(LFLAGS) -t"},
+	{Name: "YACC.y", Value: "$(YACC) $(YFLAGS)"},
+	{Name: "LINT.c", Value: "$(LINT) $(LINTFLAGS) $(CPPFLAGS) $(TARGET_ARCH)"},
+	{Name: ".LIBPATTERNS", Value: "lib%.so lib%.a"},
+}
+
+// DefineDefaults defines the built-in variables listed in DefaultVariables.
+// A variable that is already defined keeps its definition.
+func (m *Makefile) DefineDefaults() {
+	for _, v := range DefaultVariables {
+		if m.Variable(v.Name) == nil {
+			m.DefineOrigin(v.Name, v.Value, Recursive, OriginDefault)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Diagnostic functions: error, warning and info.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"error":   {MinArgs: 1, MaxArgs: 1, Call: funcError},
+		"warning": {MinArgs: 1, MaxArgs: 1, Call: funcWarning},
+		"info":    {MinArgs: 1, MaxArgs: 1, Call: funcInfo},
+	})
+}
+
+// Position identifies a line of makefile text.
+type Position struct {
+	// File is the name of the makefile, or empty if it is unknown
+	File string
+
+	// Line is the 1-based line number, or 0 if it is unknown
+	Line int
+}
+
+// String formats the position as "file:line", or returns an empty string
+// if the position is unknown.
+func (p Position) String() string {
+	if p.File == "" || p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// prefix returns the position followed by ": ", or "" if it is unknown.
+func (p Position) prefix() string {
+	if s := p.String(); s != "" {
+		return s + ": "
+	}
+	return ""
+}
+
+// Error is a fatal error raised while reading a Makefile or expanding its
+// text, such as one produced by $(error). It formats like GNU make:
+//
+//	Makefile:12: *** TOOLCHAIN not set. Stop.
+type Error struct {
+	Pos     Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s*** %s. Stop.", e.Pos.prefix(), e.Message)
+}
+
+// ErrorAt attaches pos to err, producing a GNU-style positioned error.
+// Errors that are already an *Error are returned unchanged.
+func ErrorAt(pos Position, err error) error {
+	var makeErr *Error
+	if err == nil || errors.As(err, &makeErr) {
+		return err
+	}
+	return &Error{Pos: pos, Message: err.Error()}
+}
+
+// Warn writes a warning about the text at pos to the diagnostics writer,
+// or to standard error if none is set.
+func (m *Makefile) Warn(pos Position, message string) {
+	fmt.Fprintf(m.diagnosticsOr(os.Stderr), "%s%s\n", pos.prefix(), message)
+}
+
+// diagnosticsOr returns the diagnostics writer, or def if none is set.
+func (m *Makefile) diagnosticsOr(def io.Writer) io.Writer {
+	if m.Diagnostics != nil {
+		return m.Diagnostics
+	}
+	return def
+}
+
+// $(error text) stops with a fatal error at the current position.
+func funcError(x *Expander, args []string) (string, error) {
+	return "", &Error{Pos: x.pos, Message: args[0]}
+}
+
+// $(warning text) prints text with the current position and expands to nothing.
+func funcWarning(x *Expander, args []string) (string, error) {
+	x.makefile.Warn(x.pos, args[0])
+	return "", nil
+}
+
+// $(info text) prints text and expands to nothing. Without a diagnostics
+// writer the text goes to standard output, as in GNU make.
+func funcInfo(x *Expander, args []string) (string, error) {
+	fmt.Fprintln(x.makefile.diagnosticsOr(os.Stdout), args[0])
+	return "", nil
+}
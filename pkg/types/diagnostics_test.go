@@ -0,0 +1,52 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDiagnosticFunctions(t *testing.T) {
+	var out bytes.Buffer
+	mf := NewMakefile()
+	mf.Diagnostics = &out
+	mf.Location = Position{File: "Makefile", Line: 7}
+
+	result, err := mf.Expand("a$(warning careful $(words x y))$(info hello)b")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	if result != "ab" {
+		t.Errorf("Expected warning and info to expand to nothing, got %q", result)
+	}
+
+	expected := "Makefile:7: careful 2\nhello\n"
+	if out.String() != expected {
+		t.Errorf("Diagnostics output = %q, want %q", out.String(), expected)
+	}
+}
+
+func TestErrorFunction(t *testing.T) {
+	mf := NewMakefile()
+	mf.Location = Position{File: "Makefile", Line: 3}
+
+	_, err := mf.Expand("$(if $(TOOLCHAIN),,$(error TOOLCHAIN not set))")
+	if err == nil {
+		t.Fatal("Expected $(error) to fail the expansion")
+	}
+
+	expected := "Makefile:3: *** TOOLCHAIN not set. Stop."
+	if err.Error() != expected {
+		t.Errorf("Error = %q, want %q", err.Error(), expected)
+	}
+
+	var makeErr *Error
+	if !errors.As(err, &makeErr) || makeErr.Pos.Line != 3 {
+		t.Errorf("Expected *Error at line 3, got %#v", err)
+	}
+
+	_, err = mf.ExpandAt("$(error in recipe)", nil, Position{File: "Makefile", Line: 9})
+	if err == nil || err.Error() != "Makefile:9: *** in recipe. Stop." {
+		t.Errorf("ExpandAt error = %v, want position of the recipe line", err)
+	}
+}
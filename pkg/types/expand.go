@@ -0,0 +1,347 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Function describes a make function that can be invoked as $(name arg1,arg2,...).
+//
+// Arguments are split on top-level commas, ignoring commas nested inside
+// parentheses or braces of the same kind as the call. Once MaxArgs arguments
+// have been seen, the remaining text (commas included) becomes the last argument.
+type Function struct {
+	// MinArgs is the minimum number of arguments the function requires.
+	MinArgs int
+
+	// MaxArgs is the maximum number of arguments. Zero means unlimited.
+	MaxArgs int
+
+	// Raw passes the arguments to Call unexpanded, letting the function
+	// decide when and whether each argument is expanded (as $(if) does).
+	Raw bool
+
+	// Call implements the function. Unless Raw is set, args are already expanded.
+	Call func(x *Expander, args []string) (string, error)
+}
+
+// Expander holds the state of a single expansion of make text. It resolves
+// variable references against a Makefile and dispatches function calls.
+type Expander struct {
+	makefile *Makefile
+	autoVars *AutomaticVariables
+	frames   []frame
+	pos      Position
+	scope    *Scope
+
+	// recipe is set when expanding in the context of a target, where
+	// private global variables are not visible
+	recipe bool
+
+	// expanding holds the recursive variables whose values are being
+	// expanded, to detect variables that reference themselves
+	expanding map[string]bool
+}
+
+// frame is a set of temporary variables bound by $(foreach) or $(call).
+// Their values are simple and their origin is automatic.
+type frame struct {
+	vars map[string]string
+
+	// call marks a $(call) frame, which hides the numbered parameters
+	// of any enclosing call.
+	call bool
+}
+
+// NewExpander creates an Expander for the given Makefile. autoVars may be nil
+// when no target context is available.
+func NewExpander(makefile *Makefile, autoVars *AutomaticVariables) *Expander {
+	return &Expander{makefile: makefile, autoVars: autoVars, pos: makefile.Location}
+}
+
+// Makefile returns the Makefile this Expander resolves variables against.
+func (x *Expander) Makefile() *Makefile {
+	return x.makefile
+}
+
+// Expand expands all variable references and function calls in text.
+// It supports $(VAR), ${VAR}, single-character references such as $X and $@,
+// nested references such as $(CC_$(ARCH)), and $$ as an escaped dollar sign.
+// The text is scanned exactly once: values substituted into the result are
+// never rescanned for further references.
+func (x *Expander) Expand(text string) (string, error) {
+	// Fast path: nothing to expand
+	if !strings.Contains(text, "$") {
+		return text, nil
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(text); {
+		dollar := strings.IndexByte(text[i:], '$')
+		if dollar < 0 {
+			buf.WriteString(text[i:])
+			break
+		}
+		buf.WriteString(text[i : i+dollar])
+		i += dollar
+
+		// A trailing lone $ expands to nothing, as in GNU make
+		if i+1 >= len(text) {
+			break
+		}
+
+		switch c := text[i+1]; c {
+		case '$':
+			buf.WriteByte('$')
+			i += 2
+		case '(', '{':
+			closer := closingDelimiter(c)
+			end := findClosing(text, i+2, c, closer)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference")
+			}
+			value, err := x.reference(text[i+2:end], c, closer)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(value)
+			i = end + 1
+		default:
+			value, err := x.variable(string(c))
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(value)
+			i += 2
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// reference expands the contents of a $(...) or ${...} reference, which is
+// either a function call or a (possibly computed) variable name.
+func (x *Expander) reference(content string, opener, closer byte) (string, error) {
+	if name, rest, ok := splitFunctionCall(content); ok {
+		if fn := x.makefile.lookupFunction(name); fn != nil {
+			return x.call(name, fn, rest, opener, closer)
+		}
+	}
+
+	if colon := indexUnnested(content, ':'); colon >= 0 {
+		if eq := indexUnnested(content[colon+1:], '='); eq >= 0 {
+			eq += colon + 1
+			return x.substitutionReference(content[:colon], content[colon+1:eq], content[eq+1:])
+		}
+	}
+
+	name, err := x.Expand(content)
+	if err != nil {
+		return "", err
+	}
+	return x.variable(name)
+}
+
+// substitutionReference expands $(name:from=to). When from contains a '%' it
+// is a pattern as in patsubst; otherwise from is a suffix replaced by to at
+// the end of each word.
+func (x *Expander) substitutionReference(name, from, to string) (string, error) {
+	parts := []*string{&name, &from, &to}
+	for _, part := range parts {
+		expanded, err := x.Expand(*part)
+		if err != nil {
+			return "", err
+		}
+		*part = expanded
+	}
+
+	value, err := x.variable(name)
+	if err != nil {
+		return "", err
+	}
+
+	if _, _, ok := splitPattern(from); !ok {
+		from = "%" + from
+		to = "%" + to
+	}
+	return substPattern(from, to, value), nil
+}
+
+// call splits the raw argument text of a function call and invokes the function.
+func (x *Expander) call(name string, fn *Function, argText string, opener, closer byte) (string, error) {
+	args := splitArguments(argText, opener, closer, fn.MaxArgs)
+	if len(args) < fn.MinArgs {
+		return "", fmt.Errorf("insufficient number of arguments (%d) to function '%s'", len(args), name)
+	}
+
+	if !fn.Raw {
+		for i, arg := range args {
+			expanded, err := x.Expand(arg)
+			if err != nil {
+				return "", err
+			}
+			args[i] = expanded
+		}
+	}
+
+	return fn.Call(x, args)
+}
+
+// variable returns the expanded value of the named variable. A recursive
+// variable whose value references itself, directly or through other
+// variables, is reported at the position of its definition.
+func (x *Expander) variable(name string) (string, error) {
+	if value, ok := x.local(name); ok {
+		return value, nil
+	}
+	v := x.lookup(name)
+	if v == nil {
+		return "", nil
+	}
+	if v.Flavor == Simple {
+		return v.Value, nil
+	}
+
+	if x.expanding[name] {
+		return "", &Error{Pos: v.Pos, Message: fmt.Sprintf("Recursive variable '%s' references itself (eventually)", name)}
+	}
+	if x.expanding == nil {
+		x.expanding = make(map[string]bool)
+	}
+	x.expanding[name] = true
+	defer delete(x.expanding, name)
+
+	return x.Expand(v.Value)
+}
+
+// local returns the value of a variable bound by $(foreach), $(call) or the
+// target's automatic variables, which take precedence over all others.
+func (x *Expander) local(name string) (string, bool) {
+	for i := len(x.frames) - 1; i >= 0; i-- {
+		f := x.frames[i]
+		if value, ok := f.vars[name]; ok {
+			return value, true
+		}
+		if f.call && isNumber(name) {
+			return "", true
+		}
+	}
+	if x.autoVars != nil {
+		return x.autoVars.lookup(name)
+	}
+	return "", false
+}
+
+// lookup returns the definition of a variable: a target-specific one from
+// the expander's scope, or else a global one following the Makefile's
+// precedence rules.
+func (x *Expander) lookup(name string) *Variable {
+	if v := x.scope.lookup(name); v != nil {
+		return v
+	}
+	v := x.makefile.Lookup(name)
+	if v != nil && v.Private && x.recipe {
+		return nil
+	}
+	return v
+}
+
+// withFrame runs fn with the variables in vars bound, restoring the previous
+// bindings afterwards.
+func (x *Expander) withFrame(vars map[string]string, call bool, fn func() (string, error)) (string, error) {
+	x.frames = append(x.frames, frame{vars: vars, call: call})
+	defer func() { x.frames = x.frames[:len(x.frames)-1] }()
+	return fn()
+}
+
+// isNumber reports whether name consists only of decimal digits.
+func isNumber(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < '0' || name[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitFunctionCall splits "name args" into the function name and its raw
+// argument text. The name must be followed by a space or tab.
+func splitFunctionCall(content string) (name, args string, ok bool) {
+	end := strings.IndexAny(content, " \t")
+	if end <= 0 {
+		return "", "", false
+	}
+	// Skip the blanks separating the name from the first argument
+	return content[:end], strings.TrimLeft(content[end:], " \t"), true
+}
+
+// splitArguments splits function arguments on commas that are not nested
+// inside a further opener/closer pair. When max is positive, the final
+// argument receives the rest of the text unsplit.
+func splitArguments(text string, opener, closer byte, max int) []string {
+	var args []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case opener:
+			depth++
+		case closer:
+			depth--
+		case ',':
+			if depth == 0 && (max <= 0 || len(args) < max-1) {
+				args = append(args, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, text[start:])
+}
+
+// indexUnnested returns the index of the first c in text that is not inside
+// a nested variable reference or function call, or -1 if there is none.
+func indexUnnested(text string, c byte) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+		case c:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// findClosing returns the index of the closer matching an already consumed
+// opener, starting the scan at start, or -1 if the reference is unterminated.
+func findClosing(text string, start int, opener, closer byte) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case opener:
+			depth++
+		case closer:
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// closingDelimiter returns the closing delimiter for ( or {.
+func closingDelimiter(opener byte) byte {
+	if opener == '{' {
+		return '}'
+	}
+	return ')'
+}
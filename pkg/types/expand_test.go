@@ -0,0 +1,270 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpanderExpand(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("ARCH", "arm")
+	mf.SetVariable("CC_arm", "arm-gcc")
+	mf.SetVariable("X", "ex")
+	mf.SetVariable("DOLLAR", "$(X)")
+	mf.SetVariable("SPACED NAME", "spaced")
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{
+			input:    "$(CC_$(ARCH))",
+			expected: "arm-gcc",
+			name:     "nested reference",
+		},
+		{
+			input:    "${CC_${ARCH}}",
+			expected: "arm-gcc",
+			name:     "nested reference with braces",
+		},
+		{
+			input:    "echo $$HOME",
+			expected: "echo $HOME",
+			name:     "escaped dollar",
+		},
+		{
+			input:    "$X-$(X)",
+			expected: "ex-ex",
+			name:     "single-character reference",
+		},
+		{
+			input:    "$(DOLLAR)",
+			expected: "$(X)",
+			name:     "values are not rescanned",
+		},
+		{
+			input:    "a$",
+			expected: "a",
+			name:     "trailing dollar",
+		},
+		{
+			input:    "$(SPACED NAME)",
+			expected: "spaced",
+			name:     "unknown function name is a variable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestExpanderUnterminatedReference(t *testing.T) {
+	mf := NewMakefile()
+
+	_, err := mf.Expand("$(CC")
+	if err == nil || !strings.Contains(err.Error(), "unterminated variable reference") {
+		t.Errorf("Expected unterminated variable reference error, got %v", err)
+	}
+}
+
+func TestExpanderRegisteredFunction(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("NAME", "world")
+	mf.RegisterFunction("join-args", &Function{
+		MinArgs: 1,
+		MaxArgs: 2,
+		Call: func(x *Expander, args []string) (string, error) {
+			return strings.Join(args, "|"), nil
+		},
+	})
+	mf.RegisterFunction("raw", &Function{
+		Raw: true,
+		Call: func(x *Expander, args []string) (string, error) {
+			return strings.Join(args, "|"), nil
+		},
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{
+			input:    "$(join-args hello,$(NAME))",
+			expected: "hello|world",
+			name:     "arguments are expanded",
+		},
+		{
+			input:    "$(join-args a,b,c)",
+			expected: "a|b,c",
+			name:     "last argument absorbs extra commas",
+		},
+		{
+			input:    "$(join-args (a,b),c)",
+			expected: "(a,b)|c",
+			name:     "commas inside parentheses are not separators",
+		},
+		{
+			input:    "$(raw $(NAME),x)",
+			expected: "$(NAME)|x",
+			name:     "raw arguments are not expanded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestExpanderSubstitutionReference(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("SRCDIR", "src")
+	mf.SetVariable("OBJDIR", "obj")
+	mf.SetVariable("SOURCES", "src/main.c  src/utils.c README")
+	mf.SetVariable("FROM", ".c")
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{
+			input:    "$(SOURCES:.c=.o)",
+			expected: "src/main.o src/utils.o README",
+			name:     "suffix form",
+		},
+		{
+			input:    "$(SOURCES:%.c=%.o)",
+			expected: "src/main.o src/utils.o README",
+			name:     "pattern form",
+		},
+		{
+			input:    "$(SOURCES:$(SRCDIR)/%.c=$(OBJDIR)/%.o)",
+			expected: "obj/main.o obj/utils.o README",
+			name:     "pattern with nested variables",
+		},
+		{
+			input:    "${SOURCES:$(FROM)=.h}",
+			expected: "src/main.h src/utils.h README",
+			name:     "suffix form with braces and nested variable",
+		},
+		{
+			input:    "$(SOURCES:README=NOTES)",
+			expected: "src/main.c src/utils.c NOTES",
+			name:     "suffix matching a whole word",
+		},
+		{
+			input:    "$(UNDEFINED:.c=.o)",
+			expected: "",
+			name:     "undefined variable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestSubstPattern(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		replacement string
+		text        string
+		expected    string
+	}{
+		{"%.c", "%.o", "a.c b.h c.c", "a.o b.h c.o"},
+		{"a.c", "x", "a.c b.c", "x b.c"},
+		{"%.c", "obj", "a.c b.c", "obj obj"},
+		{`\%%.c`, "%.o", "%a.c a.c", "a.o a.c"},
+		{"a%", "%", "a", ""},
+	}
+
+	for _, test := range tests {
+		result := substPattern(test.pattern, test.replacement, test.text)
+		if result != test.expected {
+			t.Errorf("substPattern(%q, %q, %q) = %q, want %q",
+				test.pattern, test.replacement, test.text, result, test.expected)
+		}
+	}
+}
+
+func TestExpanderRecursiveVariable(t *testing.T) {
+	tests := []struct {
+		name        string
+		assignments [][2]string
+		text        string
+		want        string
+		err         string
+	}{
+		{
+			name:        "direct",
+			assignments: [][2]string{{"A", "x $(A)"}},
+			text:        "$(A)",
+			err:         "Makefile:1: *** Recursive variable 'A' references itself (eventually). Stop.",
+		},
+		{
+			name:        "through another variable",
+			assignments: [][2]string{{"A", "$(B)"}, {"B", "$(A)"}},
+			text:        "$(B)",
+			err:         "Makefile:2: *** Recursive variable 'B' references itself (eventually). Stop.",
+		},
+		{
+			name:        "repeated references are not recursion",
+			assignments: [][2]string{{"A", "a"}, {"B", "$(A)$(A)"}},
+			text:        "$(B)$(B)",
+			want:        "aaaa",
+		},
+		{
+			name:        "recursive call",
+			assignments: [][2]string{{"count", "$(if $(1),x$(call count,$(wordlist 2,9,$(1))))"}},
+			text:        "$(call count,a b c)",
+			want:        "xxx",
+		},
+	}
+
+	for _, test := range tests {
+		mf := NewMakefile()
+		for i, assignment := range test.assignments {
+			mf.Location = Position{File: "Makefile", Line: i + 1}
+			mf.Assign(assignment[0], AssignRecursive, assignment[1])
+		}
+		mf.Location = Position{File: "Makefile", Line: 10}
+
+		got, err := mf.Expand(test.text)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: Expand(%q) = %q, %v, want %q", test.name, test.text, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package types
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Export marks a variable for export to the environment of recipes, or,
+// if export is false, keeps it out of that environment.
+func (m *Makefile) Export(name string, export bool) {
+	if m.Exports == nil {
+		m.Exports = make(map[string]bool)
+	}
+	m.Exports[name] = export
+}
+
+// RecipeEnvironment returns the environment for a recipe run in scope. It
+// is the Environment of the Makefile with the exported variables set to
+// their expanded values and the unexported ones removed. Without an export
+// or unexport directive, a variable is exported if it came from the
+// environment or the command line, or if all variables are exported.
+// Built-in variables and SHELL are only exported on request.
+func (m *Makefile) RecipeEnvironment(scope *Scope) ([]string, error) {
+	env := m.Environment()
+
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range m.Variables {
+		add(name)
+	}
+	for name := range m.Exports {
+		add(name)
+	}
+	for name := range m.undefined {
+		add(name)
+	}
+	for _, name := range scope.names() {
+		add(name)
+	}
+	sort.Strings(names)
+
+	x := NewExpander(m, nil)
+	x.scope = scope
+	x.recipe = true
+	for _, name := range names {
+		// MAKEFLAGS and MAKELEVEL are already set by Environment
+		if name == "MAKEFLAGS" || name == "MAKELEVEL" {
+			continue
+		}
+		v := x.lookup(name)
+
+		// An undefined variable leaves the environment of recipes too
+		if v == nil && m.undefined[name] {
+			env = unsetEnv(env, name)
+			continue
+		}
+
+		export, explicit := scope.exported(name)
+		if !explicit {
+			export, explicit = m.Exports[name]
+		}
+		if !explicit {
+			if v == nil || name == "SHELL" {
+				continue
+			}
+			_, inEnvironment := os.LookupEnv(name)
+			switch {
+			case v.Origin == OriginDefault || v.Origin == OriginAutomatic:
+				export = false
+			case m.ExportAll:
+				export = isExportableName(name)
+			default:
+				export = inEnvironment || v.Origin == OriginCommandLine
+			}
+		}
+
+		if !export || v == nil {
+			env = unsetEnv(env, name)
+			continue
+		}
+		value, err := x.variable(name)
+		if err != nil {
+			return nil, err
+		}
+		env = setEnv(env, name, value)
+	}
+	return env, nil
+}
+
+// isExportableName reports whether name is a valid shell variable name,
+// the only names that exporting all variables applies to.
+func isExportableName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// unsetEnv removes name from an environment list.
+func unsetEnv(env []string, name string) []string {
+	prefix := name + "="
+	kept := env[:0]
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, prefix) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,30 @@
+package types
+
+// builtinFunctions holds the functions available to every Makefile.
+// Each function family registers itself from its own file.
+var builtinFunctions = map[string]*Function{}
+
+// RegisterFunction makes fn callable as $(name ...) when expanding text in
+// this Makefile. It overrides any built-in function of the same name.
+func (m *Makefile) RegisterFunction(name string, fn *Function) {
+	if m.Functions == nil {
+		m.Functions = make(map[string]*Function)
+	}
+	m.Functions[name] = fn
+}
+
+// lookupFunction returns the function registered under name, preferring
+// functions registered on the Makefile over the built-in ones.
+func (m *Makefile) lookupFunction(name string) *Function {
+	if fn, ok := m.Functions[name]; ok {
+		return fn
+	}
+	return builtinFunctions[name]
+}
+
+// registerBuiltins adds a family of functions to the built-in table.
+func registerBuiltins(functions map[string]*Function) {
+	for name, fn := range functions {
+		builtinFunctions[name] = fn
+	}
+}
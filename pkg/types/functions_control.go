@@ -0,0 +1,207 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Conditional and meta functions: if, or, and, foreach, call, value, eval,
+// origin and flavor.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"if":      {MinArgs: 2, MaxArgs: 3, Raw: true, Call: funcIf},
+		"or":      {MinArgs: 1, Raw: true, Call: funcOr},
+		"and":     {MinArgs: 1, Raw: true, Call: funcAnd},
+		"foreach": {MinArgs: 3, MaxArgs: 3, Raw: true, Call: funcForeach},
+		"call":    {MinArgs: 1, Call: funcCall},
+		"value":   {MinArgs: 1, MaxArgs: 1, Call: funcValue},
+		"eval":    {MinArgs: 1, MaxArgs: 1, Call: funcEval},
+		"origin":  {MinArgs: 1, MaxArgs: 1, Call: funcOrigin},
+		"flavor":  {MinArgs: 1, MaxArgs: 1, Call: funcFlavor},
+	})
+}
+
+// $(if condition,then-part[,else-part]) expands then-part if condition
+// expands to a non-blank string, and else-part otherwise. Only the selected
+// part is expanded.
+func funcIf(x *Expander, args []string) (string, error) {
+	condition, err := x.Expand(strings.TrimSpace(args[0]))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(condition) != "" {
+		return x.Expand(args[1])
+	}
+	if len(args) > 2 {
+		return x.Expand(args[2])
+	}
+	return "", nil
+}
+
+// $(or condition1[,condition2...]) returns the first argument that expands
+// to a non-blank string, without expanding the ones after it. As with the
+// condition of $(if), surrounding whitespace is stripped from each argument
+// before it is expanded.
+func funcOr(x *Expander, args []string) (string, error) {
+	for _, arg := range args {
+		value, err := x.Expand(strings.TrimSpace(arg))
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(value) != "" {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+// $(and condition1[,condition2...]) returns the expansion of the last
+// argument if every argument expands to a non-blank string, stopping at the
+// first blank one. Each argument is stripped of surrounding whitespace
+// before it is expanded.
+func funcAnd(x *Expander, args []string) (string, error) {
+	var value string
+	for _, arg := range args {
+		var err error
+		value, err = x.Expand(strings.TrimSpace(arg))
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(value) == "" {
+			return "", nil
+		}
+	}
+	return value, nil
+}
+
+// $(foreach var,list,text) expands text once for each word of list, with
+// var bound to the word, and joins the results with spaces. As in GNU make,
+// empty results are joined too.
+func funcForeach(x *Expander, args []string) (string, error) {
+	name, err := x.Expand(args[0])
+	if err != nil {
+		return "", err
+	}
+	list, err := x.Expand(args[1])
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSpace(name)
+
+	var results []string
+	for _, word := range strings.Fields(list) {
+		result, err := x.withFrame(map[string]string{name: word}, false, func() (string, error) {
+			return x.Expand(args[2])
+		})
+		if err != nil {
+			return "", err
+		}
+		results = append(results, result)
+	}
+	return strings.Join(results, " "), nil
+}
+
+// $(call variable,param,...) expands variable with $(0) bound to its name
+// and $(1), $(2), ... bound to the parameters. Calling the name of a built-in
+// function invokes that function with the parameters.
+func funcCall(x *Expander, args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	params := args[1:]
+
+	if fn := x.makefile.lookupFunction(name); fn != nil {
+		if len(params) < fn.MinArgs {
+			return "", fmt.Errorf("insufficient number of arguments (%d) to function '%s'", len(params), name)
+		}
+		if fn.MaxArgs > 0 && len(params) > fn.MaxArgs {
+			last := strings.Join(params[fn.MaxArgs-1:], ",")
+			params = append(params[:fn.MaxArgs-1:fn.MaxArgs-1], last)
+		}
+		if fn.Raw {
+			// The arguments of call are already expanded; escaping them
+			// keeps a function that expands its own from doing it twice
+			for i, param := range params {
+				params[i] = strings.ReplaceAll(param, "$", "$$")
+			}
+		}
+		return fn.Call(x, params)
+	}
+
+	vars := map[string]string{"0": name}
+	for i, param := range params {
+		vars[strconv.Itoa(i+1)] = param
+	}
+	// A function may call itself, as long as the recursion ends through
+	// its arguments, so the variable is not yet being expanded while its
+	// own call runs
+	if x.expanding[name] {
+		delete(x.expanding, name)
+		defer func() { x.expanding[name] = true }()
+	}
+	return x.withFrame(vars, true, func() (string, error) {
+		return x.variable(name)
+	})
+}
+
+// $(value variable) returns the value of variable without expanding it.
+func funcValue(x *Expander, args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	if value, ok := x.local(name); ok {
+		return value, nil
+	}
+	if v := x.lookup(name); v != nil {
+		return v.Value, nil
+	}
+	return "", nil
+}
+
+// $(eval text) parses text as makefile syntax. It expands to nothing.
+func funcEval(x *Expander, args []string) (string, error) {
+	if x.makefile.Evaluator == nil {
+		return "", fmt.Errorf("eval is not supported: no makefile parser is attached")
+	}
+	return "", x.makefile.Evaluator(args[0])
+}
+
+// $(origin variable) reports where variable was defined.
+func funcOrigin(x *Expander, args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	if x.isLocal(name) {
+		return OriginAutomatic.String(), nil
+	}
+	if v := x.lookup(name); v != nil {
+		return v.Origin.String(), nil
+	}
+	return OriginUndefined.String(), nil
+}
+
+// $(flavor variable) reports whether variable is recursive or simple.
+func funcFlavor(x *Expander, args []string) (string, error) {
+	name := strings.TrimSpace(args[0])
+	if x.isLocal(name) {
+		return Simple.String(), nil
+	}
+	if v := x.lookup(name); v != nil {
+		return v.Flavor.String(), nil
+	}
+	return "undefined", nil
+}
+
+// isLocal reports whether name is bound by $(foreach), $(call) or the
+// target's automatic variables.
+func (x *Expander) isLocal(name string) bool {
+	for i := len(x.frames) - 1; i >= 0; i-- {
+		if _, ok := x.frames[i].vars[name]; ok {
+			return true
+		}
+		// Parameters hidden by an inner $(call) are not defined
+		if x.frames[i].call && isNumber(name) {
+			return false
+		}
+	}
+	if x.autoVars != nil {
+		_, ok := x.autoVars.lookup(name)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package types
+
+import (
+	"os"
+	"testing"
+)
+
+func TestControlFunctions(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("YES", "yes")
+	mf.SetVariable("EMPTY", "")
+	mf.Define("reverse", "$(2) $(1)", Recursive)
+	mf.Define("greet", "hello $(1)$(if $(2),$(comma)$(2))", Recursive)
+	mf.SetVariable("comma", ",")
+	mf.Define("outer", "$(call inner,x)[$(1)]", Recursive)
+	mf.Define("inner", "$(1)$(2)", Recursive)
+	mf.Define("LAZY", "$(YES)", Recursive)
+	mf.SetVariable("LITERAL", "$(YES)")
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{"$(if $(YES),then,else)", "then", "if true"},
+		{"$(if $(EMPTY),then,else)", "else", "if false"},
+		{"$(if  ,then)", "", "if false without else"},
+		{"$(if $(YES),ok,$(error not expanded))", "ok", "if expands only the selected branch"},
+		{"$(or $(EMPTY),,second,$(error not expanded))", "second", "or"},
+		{"$(or $(EMPTY),)", "", "or all empty"},
+		{"$(and a,b,c)", "c", "and"},
+		{"$(and a,,$(error not expanded))", "", "and stops at empty"},
+		{"$(or , x )", "x", "or strips its arguments"},
+		{"$(and a, $(YES) )", "yes", "and strips its arguments"},
+		{"$(foreach d,a b c,$(d).o)", "a.o b.o c.o", "foreach"},
+		{"$(foreach d,a b,$(foreach e,1 2,$(d)$(e)))", "a1 a2 b1 b2", "nested foreach"},
+		{"$(foreach x,a b,$(if $(filter a,$x),,$x))", " b", "foreach with an empty result"},
+		{"$(call reverse,a,b)", "b a", "call"},
+		{"$(call greet,world)", "hello world", "call with missing parameter"},
+		{"$(call outer,y)", "x[y]", "nested call hides outer parameters"},
+		{"$(call subst,a,b,aaa)", "bbb", "call of a built-in function"},
+		{"$(call undefined,a)", "", "call of an undefined variable"},
+		{"$(call or,$(LITERAL))", "$(YES)", "call does not expand arguments twice"},
+		{"$(call if,$(LITERAL),$(LITERAL))", "$(YES)", "call of if with expanded arguments"},
+		{"$(value LAZY)", "$(YES)", "value"},
+		{"$(LAZY)", "yes", "recursive variable"},
+		{"$(origin YES)", "file", "origin file"},
+		{"$(origin NOT_DEFINED_ANYWHERE)", "undefined", "origin undefined"},
+		{"$(origin GOMAKE_TEST_ENV)", "environment", "origin environment"},
+		{"$(foreach v,x,$(origin v))", "automatic", "origin foreach variable"},
+		{"$(flavor LAZY)", "recursive", "flavor recursive"},
+		{"$(flavor YES)", "simple", "flavor simple"},
+		{"$(flavor NOT_DEFINED_ANYWHERE)", "undefined", "flavor undefined"},
+	}
+
+	os.Setenv("GOMAKE_TEST_ENV", "1")
+	defer os.Unsetenv("GOMAKE_TEST_ENV")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestAssign(t *testing.T) {
+	mf := NewMakefile()
+	steps := []struct {
+		name, op, value string
+	}{
+		{"A", AssignRecursive, "$(B)"},
+		{"B", AssignRecursive, "one"},
+		{"S", AssignSimple, "$(B)"},
+		{"B", AssignRecursive, "two"},
+		{"A", AssignAppend, "$(B)"},
+		{"S", AssignAppend, "$(B)"},
+		{"C", AssignConditional, "first"},
+		{"C", AssignConditional, "second"},
+		{"N", AssignAppend, "new"},
+	}
+	for _, step := range steps {
+		if err := mf.Assign(step.name, step.op, step.value); err != nil {
+			t.Fatalf("Assign(%s %s %s) failed: %v", step.name, step.op, step.value, err)
+		}
+	}
+
+	expected := map[string]string{
+		"A": "two two",
+		"S": "one two",
+		"C": "first",
+		"N": "new",
+	}
+	for name, want := range expected {
+		if got, _ := mf.Expand("$(" + name + ")"); got != want {
+			t.Errorf("$(%s) = %q, want %q", name, got, want)
+		}
+	}
+
+	if flavor := mf.Variable("S").Flavor; flavor != Simple {
+		t.Errorf("Appending to a simple variable changed its flavor to %s", flavor)
+	}
+}
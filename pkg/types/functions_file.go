@@ -0,0 +1,94 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// The file function.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"file": {MinArgs: 1, MaxArgs: 2, Call: funcFile},
+	})
+}
+
+// $(file op filename[,text]) writes text to filename (op ">"), appends it
+// (op ">>") or reads the file (op "<"). Text that does not end in a newline
+// has one added when written, and a single trailing newline is removed when
+// reading. Writing expands to nothing.
+func funcFile(x *Expander, args []string) (string, error) {
+	spec := strings.TrimSpace(args[0])
+
+	var op string
+	for _, candidate := range []string{">>", ">", "<"} {
+		if strings.HasPrefix(spec, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return "", &Error{Pos: x.pos, Message: fmt.Sprintf("file: invalid file operation: %s", spec)}
+	}
+
+	filename := strings.TrimSpace(spec[len(op):])
+	if filename == "" {
+		return "", &Error{Pos: x.pos, Message: "file: missing filename"}
+	}
+	path := x.makefile.Path(filename)
+
+	if op == "<" {
+		if len(args) > 1 {
+			return "", &Error{Pos: x.pos, Message: "file: too many arguments"}
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", &Error{Pos: x.pos, Message: fmt.Sprintf("read: %s: %s", filename, describeError(err))}
+		}
+		return strings.TrimSuffix(string(data), "\n"), nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if op == ">>" {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return "", &Error{Pos: x.pos, Message: fmt.Sprintf("open: %s: %s", filename, describeError(err))}
+	}
+	defer file.Close()
+
+	// Without a text argument the file is only created or truncated
+	if len(args) > 1 {
+		text := args[1]
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if _, err := file.WriteString(text); err != nil {
+			return "", &Error{Pos: x.pos, Message: fmt.Sprintf("write: %s: %s", filename, describeError(err))}
+		}
+	}
+	if err := file.Close(); err != nil {
+		return "", &Error{Pos: x.pos, Message: fmt.Sprintf("close: %s: %s", filename, describeError(err))}
+	}
+	return "", nil
+}
+
+// describeError returns the system error message of a file operation
+// without the operation and path that os errors include.
+func describeError(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	message := err.Error()
+	if message != "" {
+		message = strings.ToUpper(message[:1]) + message[1:]
+	}
+	return message
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileFunction(t *testing.T) {
+	tmpdir := t.TempDir()
+	mf := NewMakefile()
+	mf.Dir = tmpdir
+	mf.SetVariable("OBJECTS", "a.o b.o")
+
+	steps := []struct {
+		input    string
+		expected string
+		contents string
+		name     string
+	}{
+		{"$(file >out.rsp,$(OBJECTS))", "", "a.o b.o\n", "write adds a newline"},
+		{"$(file >>out.rsp,c.o\n)", "", "a.o b.o\nc.o\n", "append keeps an existing newline"},
+		{"$(file <out.rsp)", "a.o b.o\nc.o", "a.o b.o\nc.o\n", "read strips one trailing newline"},
+		{"$(file >out.rsp,)", "", "\n", "empty text writes a newline"},
+		{"$(file >out.rsp)", "", "", "no text truncates"},
+		{"$(file <missing.rsp)", "", "", "reading a missing file is empty"},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			result, err := mf.Expand(step.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", step.input, err)
+			}
+			if result != step.expected {
+				t.Errorf("Expand(%q) = %q, want %q", step.input, result, step.expected)
+			}
+			data, _ := os.ReadFile(filepath.Join(tmpdir, "out.rsp"))
+			if string(data) != step.contents {
+				t.Errorf("After %q the file contains %q, want %q", step.input, data, step.contents)
+			}
+		})
+	}
+}
+
+func TestFileFunctionErrors(t *testing.T) {
+	mf := NewMakefile()
+	mf.Dir = t.TempDir()
+	mf.Location = Position{File: "Makefile", Line: 4}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$(file >missing/dir/out,x)", "Makefile:4: *** open: missing/dir/out: No such file or directory. Stop."},
+		{"$(file !out,x)", "Makefile:4: *** file: invalid file operation: !out. Stop."},
+		{"$(file >  ,x)", "Makefile:4: *** file: missing filename. Stop."},
+		{"$(file <out,x)", "Makefile:4: *** file: too many arguments. Stop."},
+	}
+
+	for _, test := range tests {
+		_, err := mf.Expand(test.input)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expand(%q) error = %v, want %q", test.input, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package types
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// File name functions: wildcard, dir, notdir, suffix, basename, addprefix,
+// addsuffix, join, realpath and abspath.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"wildcard":  {MinArgs: 1, MaxArgs: 1, Call: funcWildcard},
+		"dir":       {MinArgs: 1, MaxArgs: 1, Call: funcDir},
+		"notdir":    {MinArgs: 1, MaxArgs: 1, Call: funcNotdir},
+		"suffix":    {MinArgs: 1, MaxArgs: 1, Call: funcSuffix},
+		"basename":  {MinArgs: 1, MaxArgs: 1, Call: funcBasename},
+		"addprefix": {MinArgs: 2, MaxArgs: 2, Call: funcAddprefix},
+		"addsuffix": {MinArgs: 2, MaxArgs: 2, Call: funcAddsuffix},
+		"join":      {MinArgs: 2, MaxArgs: 2, Call: funcJoin},
+		"realpath":  {MinArgs: 1, MaxArgs: 1, Call: funcRealpath},
+		"abspath":   {MinArgs: 1, MaxArgs: 1, Call: funcAbspath},
+	})
+}
+
+// $(wildcard pattern...) returns the existing files matching the patterns,
+// sorted and without duplicates. Relative patterns are resolved against the
+// build directory, but each match keeps the leading directory part of its
+// pattern as written, so that ./src/*.c matches ./src/a.c. As with glob(3),
+// a wildcard does not match the leading '.' of a file name.
+func funcWildcard(x *Expander, args []string) (string, error) {
+	var matches []string
+	for _, pattern := range strings.Fields(args[0]) {
+		found, err := filepath.Glob(x.makefile.Path(pattern))
+		if err != nil {
+			// A malformed pattern matches nothing
+			continue
+		}
+		// The part of the pattern before the first wildcard is kept as is;
+		// filepath.Glob returns it cleaned
+		prefix := pattern
+		if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+			prefix = pattern[:strings.LastIndex(pattern[:i], "/")+1]
+		}
+		base := x.makefile.Path(prefix)
+		for _, match := range found {
+			switch {
+			case prefix == pattern:
+				match = pattern
+			case base != "":
+				if rel, err := filepath.Rel(base, match); err == nil {
+					match = prefix + rel
+				}
+			}
+			if hiddenMatch(pattern, match) {
+				continue
+			}
+			matches = append(matches, match)
+		}
+	}
+	return strings.Join(sortedUnique(matches), " "), nil
+}
+
+// hiddenMatch reports whether a name in match starts with a '.' that the
+// corresponding name in pattern does not, so that a wildcard matched it.
+func hiddenMatch(pattern, match string) bool {
+	patterns, names := strings.Split(pattern, "/"), strings.Split(match, "/")
+	if len(patterns) != len(names) {
+		return false
+	}
+	for i, name := range names {
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(patterns[i], ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// $(dir names...) returns the directory part of each name, up to and
+// including the last slash, or ./ if there is none.
+func funcDir(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		if slash := strings.LastIndexByte(word, '/'); slash >= 0 {
+			return word[:slash+1]
+		}
+		return "./"
+	}), nil
+}
+
+// $(notdir names...) returns everything after the last slash of each name.
+func funcNotdir(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		return word[strings.LastIndexByte(word, '/')+1:]
+	}), nil
+}
+
+// $(suffix names...) returns the suffix of each name that has one.
+func funcSuffix(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		if dot := suffixIndex(word); dot >= 0 {
+			return word[dot:]
+		}
+		return ""
+	}), nil
+}
+
+// $(basename names...) returns each name without its suffix.
+func funcBasename(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		if dot := suffixIndex(word); dot >= 0 {
+			return word[:dot]
+		}
+		return word
+	}), nil
+}
+
+// suffixIndex returns the index of the period starting the suffix of name,
+// or -1 if the last path component has no period.
+func suffixIndex(name string) int {
+	dot := strings.LastIndexByte(name, '.')
+	if dot < strings.LastIndexByte(name, '/') {
+		return -1
+	}
+	return dot
+}
+
+// $(addprefix prefix,names...) prepends prefix to each name.
+func funcAddprefix(x *Expander, args []string) (string, error) {
+	return mapWords(args[1], func(word string) string {
+		return args[0] + word
+	}), nil
+}
+
+// $(addsuffix suffix,names...) appends suffix to each name.
+func funcAddsuffix(x *Expander, args []string) (string, error) {
+	return mapWords(args[1], func(word string) string {
+		return word + args[0]
+	}), nil
+}
+
+// $(join list1,list2) concatenates the two lists word by word. Extra words
+// in the longer list are copied unchanged.
+func funcJoin(x *Expander, args []string) (string, error) {
+	first := strings.Fields(args[0])
+	second := strings.Fields(args[1])
+	if len(second) > len(first) {
+		first = append(first, make([]string, len(second)-len(first))...)
+	}
+	for i := range second {
+		first[i] += second[i]
+	}
+	return strings.Join(first, " "), nil
+}
+
+// $(realpath names...) returns the canonical absolute name of each existing
+// file, with symbolic links resolved. Names that do not exist are dropped.
+func funcRealpath(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		abs, err := filepath.Abs(x.makefile.Path(word))
+		if err != nil {
+			return ""
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return ""
+		}
+		return real
+	}), nil
+}
+
+// $(abspath names...) returns the absolute name of each file without
+// resolving symbolic links or requiring the file to exist.
+func funcAbspath(x *Expander, args []string) (string, error) {
+	return mapWords(args[0], func(word string) string {
+		abs, err := filepath.Abs(x.makefile.Path(word))
+		if err != nil {
+			return ""
+		}
+		return abs
+	}), nil
+}
+
+// mapWords applies fn to every word of text, dropping empty results, and
+// joins the results with single spaces.
+func mapWords(text string, fn func(word string) string) string {
+	var result []string
+	for _, word := range strings.Fields(text) {
+		if mapped := fn(word); mapped != "" {
+			result = append(result, mapped)
+		}
+	}
+	return strings.Join(result, " ")
+}
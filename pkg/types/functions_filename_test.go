@@ -0,0 +1,100 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilenameFunctions(t *testing.T) {
+	mf := NewMakefile()
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{"$(dir src/foo.c hacks)", "src/ ./", "dir"},
+		{"$(notdir src/foo.c hacks)", "foo.c hacks", "notdir"},
+		{"$(suffix src/foo.c src-1.0/bar.c hacks)", ".c .c", "suffix"},
+		{"$(basename src/foo.c src-1.0/bar hacks)", "src/foo src-1.0/bar hacks", "basename"},
+		{"$(addprefix src/,foo bar)", "src/foo src/bar", "addprefix"},
+		{"$(addsuffix .c,foo bar)", "foo.c bar.c", "addsuffix"},
+		{"$(join a b,.c .o)", "a.c b.o", "join"},
+		{"$(join a b c,.c)", "a.c b c", "join with longer first list"},
+		{"$(join a,.c .o)", "a.c .o", "join with longer second list"},
+		{"$(abspath /usr/lib/../bin/)", "/usr/bin", "abspath cleans the name"},
+		{"$(realpath /nonexistent/file)", "", "realpath of a missing file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestWildcardFunction(t *testing.T) {
+	tmpdir := t.TempDir()
+	os.Mkdir(filepath.Join(tmpdir, "src"), 0755)
+	for _, name := range []string{"src/b.c", "src/a.c", "src/a.h", "src/.a.c"} {
+		os.WriteFile(filepath.Join(tmpdir, name), []byte(""), 0644)
+	}
+
+	mf := NewMakefile()
+	mf.Dir = tmpdir
+	mf.SetVariable("SRCDIR", "src")
+
+	result, err := mf.Expand("$(wildcard $(SRCDIR)/*.c src/a.* missing/*.c)")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	expected := "src/a.c src/a.h src/b.c"
+	if result != expected {
+		t.Errorf("wildcard returned %q, want %q", result, expected)
+	}
+
+	// Matches keep the directory part of the pattern as written
+	result, err = mf.Expand("$(wildcard ./src/*.c ./src//a.h src/../src/b.*)")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	expected = "./src//a.h ./src/a.c ./src/b.c src/../src/b.c"
+	if result != expected {
+		t.Errorf("wildcard returned %q, want %q", result, expected)
+	}
+
+	// A wildcard does not match the leading '.' of a dot file, which
+	// only a pattern starting with '.' does
+	result, err = mf.Expand("$(wildcard src/* */*.c src/.*.c)")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	expected = "src/.a.c src/a.c src/a.h src/b.c"
+	if result != expected {
+		t.Errorf("wildcard returned %q, want %q", result, expected)
+	}
+
+	result, err = mf.Expand("$(abspath src/a.c)")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	if expected := filepath.Join(tmpdir, "src", "a.c"); result != expected {
+		t.Errorf("abspath returned %q, want %q", result, expected)
+	}
+
+	real, _ := filepath.EvalSymlinks(filepath.Join(tmpdir, "src", "a.c"))
+	result, err = mf.Expand("$(realpath src/a.c src/missing.c)")
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	if result != real {
+		t.Errorf("realpath returned %q, want %q", result, real)
+	}
+}
@@ -0,0 +1,138 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The shell function.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"shell": {MinArgs: 1, MaxArgs: 1, Call: funcShell},
+	})
+}
+
+// Defaults used when SHELL or .SHELLFLAGS is not set in the Makefile.
+const (
+	DefaultShell      = "/bin/sh"
+	DefaultShellFlags = "-c"
+)
+
+// CommandRunner runs a command given as an argument vector in dir with the
+// environment env, and returns its standard output and exit status. A command
+// that runs but exits with a non-zero status is not an error.
+type CommandRunner func(argv []string, dir string, env []string) (output []byte, status int, err error)
+
+// $(shell command) runs command through the Makefile's shell and returns its
+// output with newlines converted to spaces and trailing newlines removed.
+// The exit status is stored in .SHELLSTATUS.
+func funcShell(x *Expander, args []string) (string, error) {
+	return x.shell(args[0])
+}
+
+// shell runs command through the shell and returns its folded output.
+func (x *Expander) shell(command string) (string, error) {
+	argv, err := x.shellArgv(command)
+	if err != nil {
+		return "", err
+	}
+
+	run := x.makefile.RunCommand
+	if run == nil {
+		run = runCommand
+	}
+	output, status, err := run(argv, x.makefile.Dir, x.makefile.Environment())
+	if err != nil {
+		// Like GNU make, a shell that cannot be started yields no output
+		output, status = nil, 127
+	}
+	x.makefile.DefineOrigin(".SHELLSTATUS", strconv.Itoa(status), Simple, OriginOverride)
+
+	return foldNewlines(string(output)), nil
+}
+
+// shellArgv builds the argument vector that runs command with the shell
+// named by SHELL and the flags in .SHELLFLAGS.
+func (x *Expander) shellArgv(command string) ([]string, error) {
+	argv, err := x.shellCommand()
+	if err != nil {
+		return nil, err
+	}
+	return append(argv, command), nil
+}
+
+// shellCommand returns the shell named by SHELL followed by the flags in
+// .SHELLFLAGS, as seen from the Expander's scope. SHELL is split into words
+// like .SHELLFLAGS, so that it may name a program with arguments such as
+// "/usr/bin/env bash". Unlike other variables, SHELL is never taken from the
+// environment.
+func (x *Expander) shellCommand() ([]string, error) {
+	shell, err := x.makefileValue("SHELL", DefaultShell)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := x.makefileValue(".SHELLFLAGS", DefaultShellFlags)
+	if err != nil {
+		return nil, err
+	}
+	argv := strings.Fields(shell)
+	if len(argv) == 0 {
+		argv = []string{DefaultShell}
+	}
+	return append(argv, strings.Fields(flags)...), nil
+}
+
+// ShellInScope returns the shell that runs the recipe lines of a target
+// whose variables are in scope: the program named by SHELL followed by the
+// flags in .SHELLFLAGS, to which each line is appended. Target-specific
+// values of both variables apply.
+func (m *Makefile) ShellInScope(scope *Scope, autoVars *AutomaticVariables, pos Position) ([]string, error) {
+	x := NewExpander(m, autoVars)
+	x.pos = pos
+	x.scope = scope
+	x.recipe = true
+	return x.shellCommand()
+}
+
+// makefileValue returns the expanded value of a variable defined in the
+// Makefile or the Expander's scope, ignoring the environment, or def if it
+// is not defined.
+func (x *Expander) makefileValue(name, def string) (string, error) {
+	if x.scope.lookup(name) == nil && x.makefile.Variable(name) == nil {
+		return def, nil
+	}
+	return x.variable(name)
+}
+
+// foldNewlines removes trailing newlines from output and replaces the
+// remaining newlines (and CR LF pairs) with spaces.
+func foldNewlines(output string) string {
+	output = strings.TrimRight(output, "\r\n")
+	output = strings.ReplaceAll(output, "\r\n", " ")
+	return strings.ReplaceAll(output, "\n", " ")
+}
+
+// runCommand is the default CommandRunner. Standard error is passed through
+// to the go-make process.
+func runCommand(argv []string, dir string, env []string) ([]byte, int, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stderr = os.Stderr
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.Bytes(), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return stdout.Bytes(), 0, nil
+}
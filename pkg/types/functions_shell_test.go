@@ -0,0 +1,80 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellFunction(t *testing.T) {
+	mf := NewMakefile()
+
+	tests := []struct {
+		input    string
+		expected string
+		status   string
+		name     string
+	}{
+		{"$(shell echo hello)", "hello", "0", "simple command"},
+		{"$(shell printf 'a\\nb\\n\\n')", "a b", "0", "newlines folded and trailing ones removed"},
+		{"$(shell echo $$((1+2)))", "3", "0", "escaped dollar reaches the shell"},
+		{"$(shell exit 3)", "", "3", "exit status recorded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+			if status := mf.GetVariable(".SHELLSTATUS"); status != test.status {
+				t.Errorf(".SHELLSTATUS = %q, want %q", status, test.status)
+			}
+		})
+	}
+}
+
+func TestShellUsesMakefileShell(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("SHELL", "/bin/bash")
+	mf.SetVariable(".SHELLFLAGS", "-e -c")
+
+	var gotArgv []string
+	mf.RunCommand = func(argv []string, dir string, env []string) ([]byte, int, error) {
+		gotArgv = argv
+		return []byte("out\n"), 0, nil
+	}
+
+	if err := mf.Assign("RESULT", AssignShell, "echo $$HOME"); err != nil {
+		t.Fatalf("Assign failed: %v", err)
+	}
+
+	expected := []string{"/bin/bash", "-e", "-c", "echo $HOME"}
+	if !reflect.DeepEqual(gotArgv, expected) {
+		t.Errorf("Command run as %q, want %q", gotArgv, expected)
+	}
+	if v := mf.Variable("RESULT"); v == nil || v.Value != "out" || v.Flavor != Recursive {
+		t.Errorf("Expected recursive RESULT = \"out\", got %+v", v)
+	}
+}
+
+func TestShellWithArguments(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("SHELL", " /usr/bin/env  bash ")
+
+	var gotArgv []string
+	mf.RunCommand = func(argv []string, dir string, env []string) ([]byte, int, error) {
+		gotArgv = argv
+		return nil, 0, nil
+	}
+
+	if _, err := mf.Expand("$(shell true)"); err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	expected := []string{"/usr/bin/env", "bash", "-c", "true"}
+	if !reflect.DeepEqual(gotArgv, expected) {
+		t.Errorf("Command run as %q, want %q", gotArgv, expected)
+	}
+}
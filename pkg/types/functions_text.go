@@ -0,0 +1,197 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Text functions: subst, patsubst, strip, findstring, filter, filter-out,
+// sort, word, wordlist, words, firstword and lastword.
+func init() {
+	registerBuiltins(map[string]*Function{
+		"subst":      {MinArgs: 3, MaxArgs: 3, Call: funcSubst},
+		"patsubst":   {MinArgs: 3, MaxArgs: 3, Call: funcPatsubst},
+		"strip":      {MinArgs: 1, MaxArgs: 1, Call: funcStrip},
+		"findstring": {MinArgs: 2, MaxArgs: 2, Call: funcFindstring},
+		"filter":     {MinArgs: 2, MaxArgs: 2, Call: funcFilter},
+		"filter-out": {MinArgs: 2, MaxArgs: 2, Call: funcFilterOut},
+		"sort":       {MinArgs: 1, MaxArgs: 1, Call: funcSort},
+		"word":       {MinArgs: 2, MaxArgs: 2, Call: funcWord},
+		"wordlist":   {MinArgs: 3, MaxArgs: 3, Call: funcWordlist},
+		"words":      {MinArgs: 1, MaxArgs: 1, Call: funcWords},
+		"firstword":  {MinArgs: 1, MaxArgs: 1, Call: funcFirstword},
+		"lastword":   {MinArgs: 1, MaxArgs: 1, Call: funcLastword},
+	})
+}
+
+// $(subst from,to,text) replaces every occurrence of from with to.
+// An empty from appends to at the end of text, as GNU make does.
+func funcSubst(x *Expander, args []string) (string, error) {
+	from, to, text := args[0], args[1], args[2]
+	if from == "" {
+		return text + to, nil
+	}
+	return strings.ReplaceAll(text, from, to), nil
+}
+
+// $(patsubst pattern,replacement,text) replaces words matching pattern.
+func funcPatsubst(x *Expander, args []string) (string, error) {
+	return substPattern(args[0], args[1], args[2]), nil
+}
+
+// $(strip text) removes leading and trailing whitespace and collapses
+// internal runs of whitespace to a single space.
+func funcStrip(x *Expander, args []string) (string, error) {
+	return strings.Join(strings.Fields(args[0]), " "), nil
+}
+
+// $(findstring find,in) returns find if it occurs in in, otherwise nothing.
+func funcFindstring(x *Expander, args []string) (string, error) {
+	if strings.Contains(args[1], args[0]) {
+		return args[0], nil
+	}
+	return "", nil
+}
+
+// $(filter pattern...,text) keeps the words matching any of the patterns.
+func funcFilter(x *Expander, args []string) (string, error) {
+	return filterWords(args[0], args[1], true), nil
+}
+
+// $(filter-out pattern...,text) removes the words matching any of the patterns.
+func funcFilterOut(x *Expander, args []string) (string, error) {
+	return filterWords(args[0], args[1], false), nil
+}
+
+// filterWords returns the words of text whose match against patterns equals keep.
+func filterWords(patterns, text string, keep bool) string {
+	patternList := strings.Fields(patterns)
+	var result []string
+	for _, word := range strings.Fields(text) {
+		matched := false
+		for _, pattern := range patternList {
+			if _, ok := matchPattern(pattern, word); ok {
+				matched = true
+				break
+			}
+		}
+		if matched == keep {
+			result = append(result, word)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// $(sort list) sorts the words lexically and removes duplicates.
+func funcSort(x *Expander, args []string) (string, error) {
+	return strings.Join(sortedUnique(strings.Fields(args[0])), " "), nil
+}
+
+// sortedUnique sorts words lexically and removes duplicates in place.
+func sortedUnique(words []string) []string {
+	sort.Strings(words)
+	unique := words[:0]
+	for i, word := range words {
+		if i == 0 || word != words[i-1] {
+			unique = append(unique, word)
+		}
+	}
+	return unique
+}
+
+// $(word n,text) returns the nth word of text, counting from 1.
+func funcWord(x *Expander, args []string) (string, error) {
+	n, err := parseCount(args[0], "first", "word")
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", fmt.Errorf("first argument to 'word' function must be greater than 0")
+	}
+
+	words := strings.Fields(args[1])
+	if n > len(words) {
+		return "", nil
+	}
+	return words[n-1], nil
+}
+
+// $(wordlist s,e,text) returns words s through e of text, inclusive. Like
+// $(word), it returns the words as they appear in text, with the whitespace
+// between them kept.
+func funcWordlist(x *Expander, args []string) (string, error) {
+	start, err := parseCount(args[0], "first", "wordlist")
+	if err != nil {
+		return "", err
+	}
+	if start == 0 {
+		return "", fmt.Errorf("invalid first argument to 'wordlist' function: '%s'", strings.TrimSpace(args[0]))
+	}
+	end, err := parseCount(args[1], "second", "wordlist")
+	if err != nil {
+		return "", err
+	}
+	if start > end {
+		return "", nil
+	}
+
+	text := args[2]
+	from, to := -1, -1
+	for n, i := 1, 0; n <= end; n++ {
+		skip := strings.IndexFunc(text[i:], func(r rune) bool { return !unicode.IsSpace(r) })
+		if skip < 0 {
+			break
+		}
+		i += skip
+		if n == start {
+			from = i
+		}
+		length := strings.IndexFunc(text[i:], unicode.IsSpace)
+		if length < 0 {
+			length = len(text) - i
+		}
+		i += length
+		to = i
+	}
+	if from < 0 {
+		return "", nil
+	}
+	return text[from:to], nil
+}
+
+// $(words text) returns the number of words in text.
+func funcWords(x *Expander, args []string) (string, error) {
+	return strconv.Itoa(len(strings.Fields(args[0]))), nil
+}
+
+// $(firstword names...) returns the first word.
+func funcFirstword(x *Expander, args []string) (string, error) {
+	words := strings.Fields(args[0])
+	if len(words) == 0 {
+		return "", nil
+	}
+	return words[0], nil
+}
+
+// $(lastword names...) returns the last word.
+func funcLastword(x *Expander, args []string) (string, error) {
+	words := strings.Fields(args[0])
+	if len(words) == 0 {
+		return "", nil
+	}
+	return words[len(words)-1], nil
+}
+
+// parseCount parses a non-negative numeric function argument, producing
+// GNU make's diagnostics for the given argument position and function.
+func parseCount(arg, position, function string) (int, error) {
+	trimmed := strings.TrimSpace(arg)
+	n, err := strconv.Atoi(trimmed)
+	if err != nil || n < 0 || strings.ContainsAny(trimmed, "+-") {
+		return 0, fmt.Errorf("non-numeric %s argument to '%s' function: '%s'", position, function, trimmed)
+	}
+	return n, nil
+}
@@ -0,0 +1,77 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextFunctions(t *testing.T) {
+	mf := NewMakefile()
+	mf.SetVariable("SRC", "main.c util.c util.h README")
+	mf.SetVariable("COMMA", ",")
+
+	tests := []struct {
+		input    string
+		expected string
+		name     string
+	}{
+		{"$(subst ee,EE,feet on the street)", "fEEt on the strEEt", "subst"},
+		{"$(subst ,x,abc)", "abcx", "subst with empty from"},
+		{"$(subst $(COMMA), ,a,b,c)", "a b c", "subst with comma from a variable"},
+		{"$(patsubst %.c,%.o,$(SRC))", "main.o util.o util.h README", "patsubst"},
+		{"$(strip   a   b  c  )", "a b c", "strip"},
+		{"$(findstring a,a b c)", "a", "findstring found"},
+		{"$(findstring a,b c)", "", "findstring not found"},
+		{"$(filter %.c %.h,$(SRC))", "main.c util.c util.h", "filter"},
+		{"$(filter-out %.c,$(SRC))", "util.h README", "filter-out"},
+		{"$(sort foo bar lose foo)", "bar foo lose", "sort"},
+		{"$(word 2,foo bar baz)", "bar", "word"},
+		{"$(word 4,foo bar baz)", "", "word out of range"},
+		{"$(wordlist 2,3,foo bar baz)", "bar baz", "wordlist"},
+		{"$(wordlist 2,9,foo bar baz)", "bar baz", "wordlist past end"},
+		{"$(wordlist 3,2,foo bar baz)", "", "wordlist reversed"},
+		{"$(wordlist 2,3,foo  bar\tbaz  qux)", "bar\tbaz", "wordlist keeps whitespace"},
+		{"$(wordlist 2,9,foo bar  baz  )", "bar  baz", "wordlist past end keeps whitespace"},
+		{"$(wordlist 4,5,foo bar baz)", "", "wordlist past the words"},
+		{"$(words foo bar baz)", "3", "words"},
+		{"$(words )", "0", "words empty"},
+		{"$(firstword foo bar)", "foo", "firstword"},
+		{"$(lastword foo bar)", "bar", "lastword"},
+		{"$(firstword $(filter %.h,$(SRC)))", "util.h", "nested calls"},
+		{"$(subst (a,b),x,(a,b) c)", "x c", "nested parentheses in arguments"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := mf.Expand(test.input)
+			if err != nil {
+				t.Fatalf("Expand(%q) failed: %v", test.input, err)
+			}
+			if result != test.expected {
+				t.Errorf("Expand(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestTextFunctionErrors(t *testing.T) {
+	mf := NewMakefile()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$(word x,foo)", "non-numeric first argument to 'word' function"},
+		{"$(word 0,foo)", "first argument to 'word' function must be greater than 0"},
+		{"$(wordlist 0,1,foo)", "invalid first argument to 'wordlist' function"},
+		{"$(wordlist 1,x,foo)", "non-numeric second argument to 'wordlist' function"},
+		{"$(subst a,b)", "insufficient number of arguments (2) to function 'subst'"},
+	}
+
+	for _, test := range tests {
+		_, err := mf.Expand(test.input)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expand(%q) error = %v, want %q", test.input, err, test.expected)
+		}
+	}
+}
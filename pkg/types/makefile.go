@@ -1,6 +1,15 @@
 // Package types defines the core data structures used throughout the go-make project.
 package types
 
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
 // Rule represents a single target rule in a Makefile.
 // A rule consists of a target name, its dependencies, and the commands to build it.
 //
@@ -20,9 +29,33 @@ type Rule struct {
 	
 	// Dependencies are the files or targets that this target depends on
 	Dependencies []string
+
+	// OrderOnly are prerequisites listed after a '|'. They are built before
+	// the target but never make it out of date.
+	OrderOnly []string
 	
 	// Commands are the shell commands to execute when building this target
 	Commands []string
+
+	// Pos is where the rule was defined
+	Pos Position
+
+	// CommandPos holds the position of each command, parallel to Commands.
+	// Rules constructed in code may leave it empty.
+	CommandPos []Position
+
+	// Stem is the part of the target matched by the '%' of a pattern rule.
+	// It is set on rules instantiated from a pattern rule.
+	Stem string
+}
+
+// CommandPosition returns the position of the i-th command, falling back to
+// the position of the rule when it is not recorded.
+func (r *Rule) CommandPosition(i int) Position {
+	if i < len(r.CommandPos) {
+		return r.CommandPos[i]
+	}
+	return r.Pos
 }
 
 // Makefile represents a parsed Makefile with all its rules.
@@ -38,14 +71,80 @@ type Makefile struct {
 	FirstRule string
 	
 	// Variables stores variable definitions from the Makefile (VAR = value)
-	Variables map[string]string
+	Variables map[string]*Variable
+
+	// PatternRules are the implicit rules defined in the Makefile, such as
+	// "%.o: %.c", in the order they were defined. Their Target and
+	// prerequisites contain a '%'.
+	PatternRules []*Rule
+
+	// BuiltinRules are the built-in implicit rules, searched after the
+	// PatternRules. A ".SUFFIXES:" line with no prerequisites clears them.
+	BuiltinRules []*Rule
+
+	// TargetVariables are the target-specific and pattern-specific
+	// variable assignments, in the order they were written
+	TargetVariables []*TargetVariable
+
+	// Exports records the variables named by export (true) and unexport
+	// (false) directives. Variables not listed are exported if they came
+	// from the environment or the command line, or if ExportAll is set.
+	Exports map[string]bool
+
+	// ExportAll exports every variable, as a bare export directive or the
+	// .EXPORT_ALL_VARIABLES special target do.
+	ExportAll bool
+
+	// undefined records the variables removed by Undefine, so that an
+	// environment variable of the same name stays hidden
+	undefined map[string]bool
+
+	// Dir is the build directory that relative file names are resolved
+	// against. An empty Dir means the current working directory.
+	Dir string
+
+	// Functions holds functions registered with RegisterFunction, in
+	// addition to the built-in ones.
+	Functions map[string]*Function
+
+	// RunCommand runs the commands of $(shell) and != assignments.
+	// If nil, commands are run with os/exec.
+	RunCommand CommandRunner
+
+	// Diagnostics receives the output of $(warning) and $(info) and other
+	// warnings. If nil, warnings go to standard error and $(info) text to
+	// standard output.
+	Diagnostics io.Writer
+
+	// EnvironmentOverrides gives environment variables precedence over
+	// assignments in the Makefile, like GNU make's -e option.
+	EnvironmentOverrides bool
+
+	// Location is the position of the makefile text currently being read.
+	// The parser keeps it up to date so that diagnostics raised while
+	// expanding text can report where they came from.
+	Location Position
+
+	// Evaluator parses makefile text into this Makefile. The makefile
+	// package installs it so that $(eval) can feed text back to the parser.
+	Evaluator func(text string) error
+}
+
+// DefaultSuffixes is GNU make's default suffix list. A target ending in one
+// of these suffixes has the rest of its name as its stem ($*) when it is
+// built by an explicit rule.
+var DefaultSuffixes = []string{
+	".out", ".a", ".ln", ".o", ".c", ".cc", ".C", ".cpp", ".p", ".f", ".F",
+	".m", ".r", ".y", ".l", ".ym", ".yl", ".s", ".S", ".mod", ".sym", ".def",
+	".h", ".info", ".dvi", ".tex", ".texinfo", ".texi", ".txinfo", ".w",
+	".ch", ".web", ".sh", ".elc", ".el",
 }
 
 // NewMakefile creates a new empty Makefile with initialized maps.
 func NewMakefile() *Makefile {
 	return &Makefile{
 		Rules:     make(map[string]*Rule),
-		Variables: make(map[string]string),
+		Variables: make(map[string]*Variable),
 	}
 }
 
@@ -69,14 +168,164 @@ func (m *Makefile) Targets() []string {
 	return targets
 }
 
-// SetVariable sets a variable in the Makefile.
+// Path resolves a file name relative to the build directory.
+func (m *Makefile) Path(name string) string {
+	if m.Dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(m.Dir, name)
+}
+
+// SetVariable sets a variable in the Makefile. The value is stored as a
+// simply expanded variable and is not expanded again when referenced.
 func (m *Makefile) SetVariable(name, value string) {
-	m.Variables[name] = value
+	m.Define(name, value, Simple)
+}
+
+// Define stores a variable with the given flavor as if it had been assigned
+// in the Makefile, replacing any previous definition that does not take
+// precedence over it.
+func (m *Makefile) Define(name, value string, flavor Flavor) {
+	m.DefineOrigin(name, value, flavor, OriginFile)
+}
+
+// DefineOrigin stores a variable with the given flavor and origin. The
+// definition is ignored if the variable is already defined with an origin
+// that takes precedence, such as a command line variable over a file one.
+func (m *Makefile) DefineOrigin(name, value string, flavor Flavor, origin Origin) {
+	if !m.canDefine(name, origin) {
+		return
+	}
+	if m.Variables == nil {
+		m.Variables = make(map[string]*Variable)
+	}
+	delete(m.undefined, name)
+	m.Variables[name] = &Variable{Name: name, Value: value, Flavor: flavor, Origin: origin, Pos: m.Location}
+}
+
+// canDefine reports whether a definition with the given origin may replace
+// the current definition of name.
+func (m *Makefile) canDefine(name string, origin Origin) bool {
+	if existing := m.Variables[name]; existing != nil && existing.Origin > origin {
+		return false
+	}
+	// With -e the environment overrides assignments in the Makefile
+	if m.EnvironmentOverrides && origin < OriginEnvironmentOverride {
+		return m.environmentVariable(name) == nil
+	}
+	return true
+}
+
+// Undefine removes the definition of name, as the undefine directive does,
+// unless it is defined with an origin that takes precedence over origin.
+// An environment variable of the same name is hidden as well.
+func (m *Makefile) Undefine(name string, origin Origin) {
+	if !m.canDefine(name, origin) {
+		return
+	}
+	delete(m.Variables, name)
+	if m.undefined == nil {
+		m.undefined = make(map[string]bool)
+	}
+	m.undefined[name] = true
+}
+
+// MarkPrivate marks name as private if it is defined with origin, so that
+// it is not inherited by targets.
+func (m *Makefile) MarkPrivate(name string, origin Origin) {
+	if v := m.Variables[name]; v != nil && v.Origin == origin {
+		v.Private = true
+	}
+}
+
+// Variable returns the definition of a variable, or nil if it is not defined
+// in the Makefile.
+func (m *Makefile) Variable(name string) *Variable {
+	return m.Variables[name]
+}
+
+// Lookup returns the definition that a reference to name resolves to, or nil
+// if the variable is undefined. Precedence follows GNU make: environment
+// variables override defaults, Makefile definitions override the
+// environment unless EnvironmentOverrides is set, and command line and
+// override definitions win over both.
+func (m *Makefile) Lookup(name string) *Variable {
+	v := m.Variables[name]
+	if v != nil && v.Origin > OriginFile {
+		return v
+	}
+	if env := m.environmentVariable(name); env != nil {
+		if v == nil || v.Origin < env.Origin {
+			return env
+		}
+	}
+	return v
+}
+
+// Origin reports where the variable a reference to name resolves to was
+// defined, or OriginUndefined if it is not defined at all.
+func (m *Makefile) Origin(name string) Origin {
+	if v := m.Lookup(name); v != nil {
+		return v.Origin
+	}
+	return OriginUndefined
+}
+
+// environmentVariable returns the environment variable name as a recursive
+// Variable, or nil if it is not set or has been undefined. As in GNU make,
+// SHELL is never taken from the environment, since the user's login shell
+// need not be a POSIX shell.
+func (m *Makefile) environmentVariable(name string) *Variable {
+	if name == "SHELL" || m.undefined[name] {
+		return nil
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	origin := OriginEnvironment
+	if m.EnvironmentOverrides {
+		origin = OriginEnvironmentOverride
+	}
+	return &Variable{Name: name, Value: value, Flavor: Recursive, Origin: origin}
+}
+
+// Environment returns the environment for commands run on behalf of the
+// Makefile: the process environment with MAKEFLAGS replaced by the
+// Makefile's own value, so that sub-makes inherit its options, and
+// MAKELEVEL increased by one.
+func (m *Makefile) Environment() []string {
+	env := os.Environ()
+	if v := m.Variables["MAKEFLAGS"]; v != nil {
+		env = setEnv(env, "MAKEFLAGS", v.Value)
+	}
+	if v := m.Lookup("MAKELEVEL"); v != nil {
+		level, _ := strconv.Atoi(strings.TrimSpace(v.Value))
+		env = setEnv(env, "MAKELEVEL", strconv.Itoa(level+1))
+	}
+	return env
+}
+
+// setEnv sets name to value in an environment list, replacing any
+// existing entry.
+func setEnv(env []string, name, value string) []string {
+	prefix := name + "="
+	for i, entry := range env {
+		if strings.HasPrefix(entry, prefix) {
+			env[i] = prefix + value
+			return env
+		}
+	}
+	return append(env, prefix+value)
 }
 
 // GetVariable returns the value of a variable, or empty string if not found.
+// The value of a recursive variable is returned unexpanded.
 func (m *Makefile) GetVariable(name string) string {
-	return m.Variables[name]
+	if v := m.Variables[name]; v != nil {
+		return v.Value
+	}
+	return ""
 }
 
 // HasVariable returns true if the variable is defined.
@@ -85,14 +334,112 @@ func (m *Makefile) HasVariable(name string) bool {
 	return exists
 }
 
+// Assign performs a variable assignment as written in the Makefile. See
+// AssignOrigin for the semantics of each operator.
+func (m *Makefile) Assign(name, op, value string) error {
+	return m.AssignOrigin(name, op, value, OriginFile)
+}
+
+// AssignOrigin performs a variable assignment with one of the assignment
+// operators and the given origin, applying GNU make semantics:
+//   - "=" stores the value unexpanded (recursive)
+//   - ":=" and "::=" expand the value now (simple)
+//   - "?=" assigns recursively only if the variable is not yet defined
+//   - "+=" appends, keeping the flavor of the existing variable
+//   - "!=" runs the expanded value as a shell command and stores its output
+//
+// The assignment has no effect if the variable is already defined with an
+// origin that takes precedence over origin.
+func (m *Makefile) AssignOrigin(name, op, value string, origin Origin) error {
+	if !m.canDefine(name, origin) {
+		return nil
+	}
+	existing := m.Lookup(name)
+
+	switch op {
+	case AssignRecursive:
+		m.DefineOrigin(name, value, Recursive, origin)
+	case AssignSimple, AssignPosix:
+		expanded, err := m.Expand(value)
+		if err != nil {
+			return err
+		}
+		m.DefineOrigin(name, expanded, Simple, origin)
+	case AssignConditional:
+		if existing == nil {
+			m.DefineOrigin(name, value, Recursive, origin)
+		}
+	case AssignAppend:
+		if existing == nil {
+			m.DefineOrigin(name, value, Recursive, origin)
+			return nil
+		}
+		if existing.Flavor == Simple {
+			expanded, err := m.Expand(value)
+			if err != nil {
+				return err
+			}
+			value = expanded
+		}
+		if existing.Value != "" {
+			value = existing.Value + " " + value
+		}
+		m.DefineOrigin(name, value, existing.Flavor, origin)
+	case AssignShell:
+		command, err := m.Expand(value)
+		if err != nil {
+			return err
+		}
+		output, err := NewExpander(m, nil).shell(command)
+		if err != nil {
+			return err
+		}
+		m.DefineOrigin(name, output, Recursive, origin)
+	default:
+		return fmt.Errorf("unknown assignment operator '%s'", op)
+	}
+	return nil
+}
+
 // ExpandVariables expands all variable references in the given string.
-// Supports both $(VAR) and ${VAR} syntax.
+// Supports both $(VAR) and ${VAR} syntax. Expansion errors yield an empty
+// string; use Expand to observe them.
 func (m *Makefile) ExpandVariables(text string) string {
-	return expandVariables(text, m.Variables)
+	expanded, _ := m.Expand(text)
+	return expanded
 }
 
 // ExpandVariablesWithContext expands variables including automatic variables.
 // Used during command execution when we know the target context.
 func (m *Makefile) ExpandVariablesWithContext(text string, autoVars *AutomaticVariables) string {
-	return expandVariablesWithContext(text, m.Variables, autoVars)
+	expanded, _ := m.ExpandWithContext(text, autoVars)
+	return expanded
+}
+
+// Expand expands all variable references and function calls in text,
+// reporting malformed references and function errors.
+func (m *Makefile) Expand(text string) (string, error) {
+	return NewExpander(m, nil).Expand(text)
+}
+
+// ExpandWithContext is like Expand but also resolves the automatic variables
+// of the target being built.
+func (m *Makefile) ExpandWithContext(text string, autoVars *AutomaticVariables) (string, error) {
+	return NewExpander(m, autoVars).Expand(text)
+}
+
+// ExpandAt is like ExpandWithContext but reports diagnostics against pos
+// rather than the current Location.
+func (m *Makefile) ExpandAt(text string, autoVars *AutomaticVariables, pos Position) (string, error) {
+	return m.ExpandInScope(text, nil, autoVars, pos)
+}
+
+// ExpandInScope is like ExpandAt but also resolves the target-specific
+// variables of scope. The builder uses it for recipe lines.
+func (m *Makefile) ExpandInScope(text string, scope *Scope, autoVars *AutomaticVariables, pos Position) (string, error) {
+	x := NewExpander(m, autoVars)
+	x.pos = pos
+	x.scope = scope
+	x.recipe = true
+	return x.Expand(text)
 }
\ No newline at end of file
@@ -2,6 +2,7 @@ package types
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -96,4 +97,59 @@ func TestRule(t *testing.T) {
 	if !reflect.DeepEqual(rule.Commands, expectedCommands) {
 		t.Errorf("Expected commands %v, got %v", expectedCommands, rule.Commands)
 	}
-}
\ No newline at end of file
+}
+
+func TestRuleInstantiate(t *testing.T) {
+	tests := []struct {
+		pattern *Rule
+		target  string
+		ok      bool
+		stem    string
+		deps    []string
+	}{
+		{&Rule{Target: "%.o", Dependencies: []string{"%.c", "config.h"}}, "main.o", true, "main", []string{"main.c", "config.h"}},
+		{&Rule{Target: "%.o", Dependencies: []string{"%.c"}}, "src/main.o", true, "src/main", []string{"src/main.c"}},
+		{&Rule{Target: "obj/%.o", Dependencies: []string{"src/%.c"}}, "obj/a/b.o", true, "a/b", []string{"src/a/b.c"}},
+		{&Rule{Target: "e%t", Dependencies: []string{"c%r"}}, "src/eat", true, "src/a", []string{"src/car"}},
+		{&Rule{Target: "(%)", Dependencies: []string{"%"}}, "lib.a(m.o)", true, "m.o", []string{"m.o"}},
+		{&Rule{Target: "%.o", Dependencies: []string{"%.c"}}, "main.c", false, "", nil},
+	}
+
+	for _, test := range tests {
+		rule, ok := test.pattern.Instantiate(test.target)
+		if ok != test.ok {
+			t.Errorf("%s.Instantiate(%q) ok = %v, want %v", test.pattern.Target, test.target, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if rule.Target != test.target || rule.Stem != test.stem || !reflect.DeepEqual(rule.Dependencies, test.deps) {
+			t.Errorf("%s.Instantiate(%q) = %s (stem %q): %v, want stem %q: %v",
+				test.pattern.Target, test.target, rule.Target, rule.Stem, rule.Dependencies, test.stem, test.deps)
+		}
+	}
+}
+
+func TestPrintDatabase(t *testing.T) {
+	mf := NewMakefile()
+	mf.DefineOrigin("CC", "cc", Recursive, OriginDefault)
+	mf.Define("OBJS", "a.o", Simple)
+	mf.PatternRules = []*Rule{{Target: "%.o", Dependencies: []string{"%.c"}, Commands: []string{"$(CC) -c $<"}, Pos: Position{File: "Makefile", Line: 3}}}
+	mf.Rules["app"] = &Rule{Target: "app", Dependencies: []string{"a.o"}, OrderOnly: []string{"bin"}}
+
+	var buf strings.Builder
+	if err := mf.PrintDatabase(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# default\nCC = cc\n",
+		"# file\nOBJS := a.o\n",
+		"%.o: %.c\n#  recipe to execute (from 'Makefile', line 3):\n\t$(CC) -c $<\n",
+		"app: a.o | bin\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Database does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
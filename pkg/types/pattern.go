@@ -0,0 +1,136 @@
+package types
+
+import "strings"
+
+// splitPattern splits a pattern at its first unescaped '%' into prefix and
+// suffix, removing backslashes that quote a '%'. ok is false if the pattern
+// contains no '%'; prefix then holds the unquoted pattern.
+func splitPattern(pattern string) (prefix, suffix string, ok bool) {
+	var buf strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '%' {
+			return buf.String(), pattern[i+1:], true
+		}
+		if pattern[i] != '\\' {
+			buf.WriteByte(pattern[i])
+			continue
+		}
+
+		// Backslashes only quote when they precede a '%': each pair then
+		// stands for one backslash, and an odd one out quotes the '%'
+		run := i
+		for run < len(pattern) && pattern[run] == '\\' {
+			run++
+		}
+		count := run - i
+		if run == len(pattern) || pattern[run] != '%' {
+			buf.WriteString(pattern[i:run])
+		} else {
+			buf.WriteString(strings.Repeat("\\", count/2))
+			if count%2 == 1 {
+				buf.WriteByte('%')
+				run++
+			}
+		}
+		i = run - 1
+	}
+	return buf.String(), "", false
+}
+
+// matchPattern reports whether word matches pattern, returning the text
+// matched by the '%' (the stem). A pattern without '%' must match exactly.
+func matchPattern(pattern, word string) (stem string, ok bool) {
+	prefix, suffix, hasPercent := splitPattern(pattern)
+	if !hasPercent {
+		return "", word == prefix
+	}
+	if len(word) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(word, prefix) || !strings.HasSuffix(word, suffix) {
+		return "", false
+	}
+	return word[len(prefix) : len(word)-len(suffix)], true
+}
+
+// substPattern replaces each whitespace-separated word of text that matches
+// pattern with replacement, where the first '%' in replacement stands for the
+// stem. Words that do not match are kept unchanged. The result is joined with
+// single spaces, as in GNU make's patsubst.
+func substPattern(pattern, replacement string, text string) string {
+	_, _, patternHasPercent := splitPattern(pattern)
+	replPrefix, replSuffix, replHasPercent := splitPattern(replacement)
+
+	words := strings.Fields(text)
+	for i, word := range words {
+		stem, ok := matchPattern(pattern, word)
+		if !ok {
+			continue
+		}
+		switch {
+		case !replHasPercent:
+			words[i] = replPrefix
+		case patternHasPercent:
+			words[i] = replPrefix + stem + replSuffix
+		default:
+			words[i] = replPrefix + "%" + replSuffix
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// IsPattern reports whether name contains an unquoted '%', making a rule
+// with that target a pattern rule.
+func IsPattern(name string) bool {
+	_, _, ok := splitPattern(name)
+	return ok
+}
+
+// IsMatchAnything reports whether r is a pattern rule whose target is a
+// lone '%', which matches any file name.
+func (r *Rule) IsMatchAnything() bool {
+	return r.Target == "%"
+}
+
+// Instantiate applies the pattern rule r to target. It returns a rule for
+// target whose prerequisites have each '%' replaced by the stem, or false if
+// target does not match the rule's target pattern.
+//
+// As in GNU make, when the target pattern contains no slash the directory
+// part of target is removed before matching and added back to the stem and
+// to each prerequisite containing a '%'. An archive member target such as
+// lib.a(m.o) is matched in its "(m.o)" form, as the pattern "(%)" expects.
+func (r *Rule) Instantiate(target string) (*Rule, bool) {
+	name, dir := target, ""
+	if open := strings.IndexByte(target, '('); open > 0 && strings.HasSuffix(target, ")") && strings.HasPrefix(r.Target, "(") {
+		name = target[open:]
+	} else if !strings.Contains(r.Target, "/") {
+		if slash := strings.LastIndexByte(target, '/'); slash >= 0 {
+			name, dir = target[slash+1:], target[:slash+1]
+		}
+	}
+
+	stem, ok := matchPattern(r.Target, name)
+	if !ok {
+		return nil, false
+	}
+
+	instantiate := func(patterns []string) []string {
+		words := make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			if prefix, suffix, ok := splitPattern(pattern); ok {
+				pattern = dir + prefix + stem + suffix
+			}
+			words = append(words, pattern)
+		}
+		return words
+	}
+
+	return &Rule{
+		Target:       target,
+		Dependencies: instantiate(r.Dependencies),
+		OrderOnly:    instantiate(r.OrderOnly),
+		Commands:     r.Commands,
+		Pos:          r.Pos,
+		CommandPos:   r.CommandPos,
+		Stem:         dir + stem,
+	}, true
+}
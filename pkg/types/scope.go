@@ -0,0 +1,210 @@
+package types
+
+import "fmt"
+
+// TargetVariable is a target-specific variable assignment, such as
+// "debug: CFLAGS += -g", or a pattern-specific one, such as
+// "%.o: CFLAGS += -fPIC". It takes effect while the target, and the
+// prerequisites built on its behalf, are being built.
+type TargetVariable struct {
+	// Target is the target name, or a pattern containing a '%'
+	Target string
+
+	// Name, Op and Value describe the assignment. The value of a simple
+	// assignment (:=, ::= or !=) is stored already expanded.
+	Name  string
+	Op    string
+	Value string
+
+	// Origin is the origin of the assignment, normally OriginFile
+	Origin Origin
+
+	// Export is set when the assignment was written with export
+	Export bool
+
+	// Private is set when the assignment was written with private, so
+	// that the prerequisites of the target do not inherit it
+	Private bool
+
+	// Pos is where the assignment was written
+	Pos Position
+}
+
+// Scope holds the target-specific variables in effect while a target is
+// built. Each scope inherits the variables of the target that caused it to
+// be built; the global variables of the Makefile lie beneath them all.
+// A nil *Scope is the global scope.
+type Scope struct {
+	parent  *Scope
+	vars    map[string]*Variable
+	exports map[string]bool
+}
+
+// lookup returns the innermost target-specific definition of name, or nil.
+// Private variables are only visible in the scope that defines them.
+func (s *Scope) lookup(name string) *Variable {
+	for inner := s; s != nil; s = s.parent {
+		if v := s.vars[name]; v != nil && (!v.Private || s == inner) {
+			return v
+		}
+	}
+	return nil
+}
+
+// exported returns the innermost export setting for name, and whether any
+// scope has one.
+func (s *Scope) exported(name string) (export bool, ok bool) {
+	for ; s != nil; s = s.parent {
+		if export, ok := s.exports[name]; ok {
+			return export, true
+		}
+	}
+	return false, false
+}
+
+// hasPrivate reports whether the scope itself defines a private variable.
+// Those of its parents are already hidden from it.
+func (s *Scope) hasPrivate() bool {
+	if s == nil {
+		return false
+	}
+	for _, v := range s.vars {
+		if v.Private {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the names of all target-specific variables in the scope
+// and its parents.
+func (s *Scope) names() []string {
+	var names []string
+	for ; s != nil; s = s.parent {
+		for name := range s.vars {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// AddTargetVariable records a target-specific or pattern-specific variable
+// assignment. Simple assignments are expanded now, in the global scope, as
+// GNU make does.
+func (m *Makefile) AddTargetVariable(tv *TargetVariable) error {
+	switch tv.Op {
+	case AssignRecursive, AssignConditional, AssignAppend:
+	case AssignSimple, AssignPosix:
+		expanded, err := m.Expand(tv.Value)
+		if err != nil {
+			return err
+		}
+		tv.Value = expanded
+	case AssignShell:
+		command, err := m.Expand(tv.Value)
+		if err != nil {
+			return err
+		}
+		output, err := NewExpander(m, nil).shell(command)
+		if err != nil {
+			return err
+		}
+		tv.Value = output
+	default:
+		return fmt.Errorf("unknown assignment operator '%s'", tv.Op)
+	}
+	m.TargetVariables = append(m.TargetVariables, tv)
+	return nil
+}
+
+// NewScope returns the scope in which target is built when it is built on
+// behalf of a target with scope parent. Pattern-specific variables are
+// applied before target-specific ones, so the latter take precedence. If
+// no variables apply to target, it is built in a scope of its own only when
+// it must not see the private variables of parent; otherwise parent itself
+// is returned.
+func (m *Makefile) NewScope(target string, parent *Scope) (*Scope, error) {
+	var scope *Scope
+	for _, pattern := range []bool{true, false} {
+		for _, tv := range m.TargetVariables {
+			if IsPattern(tv.Target) != pattern {
+				continue
+			}
+			if pattern {
+				if _, ok := matchPattern(tv.Target, target); !ok {
+					continue
+				}
+			} else if tv.Target != target {
+				continue
+			}
+
+			if scope == nil {
+				scope = &Scope{parent: parent, vars: make(map[string]*Variable), exports: make(map[string]bool)}
+			}
+			if err := m.applyTargetVariable(scope, tv); err != nil {
+				return nil, ErrorAt(tv.Pos, err)
+			}
+		}
+	}
+	if scope == nil && parent.hasPrivate() {
+		scope = &Scope{parent: parent}
+	}
+	if scope == nil {
+		return parent, nil
+	}
+	return scope, nil
+}
+
+// applyTargetVariable performs a target-specific assignment in scope. As
+// with global assignments, it has no effect if the variable is defined with
+// an origin that takes precedence, such as on the command line.
+func (m *Makefile) applyTargetVariable(scope *Scope, tv *TargetVariable) error {
+	if tv.Export {
+		scope.exports[tv.Name] = true
+	}
+
+	existing := scope.lookup(tv.Name)
+	if existing == nil {
+		existing = m.Lookup(tv.Name)
+	}
+	// A definition of higher precedence, such as one on the command line,
+	// wins over an assignment. As in GNU make, an append still adds to an
+	// override, but not to any other such definition.
+	if existing != nil && existing.Origin > tv.Origin && (tv.Op != AssignAppend || existing.Origin != OriginOverride) {
+		return nil
+	}
+
+	v := &Variable{Name: tv.Name, Value: tv.Value, Flavor: Recursive, Origin: tv.Origin, Pos: tv.Pos, Private: tv.Private}
+	if existing != nil && existing.Origin > v.Origin {
+		v.Origin = existing.Origin
+	}
+	switch tv.Op {
+	case AssignSimple, AssignPosix, AssignShell:
+		v.Flavor = Simple
+	case AssignConditional:
+		if existing != nil {
+			return nil
+		}
+	case AssignAppend:
+		if existing == nil {
+			break
+		}
+		v.Flavor = existing.Flavor
+		value := tv.Value
+		if existing.Flavor == Simple {
+			x := NewExpander(m, nil)
+			x.scope = scope
+			expanded, err := x.Expand(value)
+			if err != nil {
+				return err
+			}
+			value = expanded
+		}
+		if existing.Value != "" {
+			value = existing.Value + " " + value
+		}
+		v.Value = value
+	}
+	scope.vars[tv.Name] = v
+	return nil
+}
@@ -0,0 +1,167 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewScope(t *testing.T) {
+	mf := NewMakefile()
+	mf.Assign("CFLAGS", AssignRecursive, "-O2")
+	mf.Assign("LEVEL", AssignSimple, "1")
+	mf.AssignOrigin("CC", AssignRecursive, "clang", OriginCommandLine)
+	mf.AssignOrigin("OPT", AssignSimple, "-O", OriginOverride)
+	for _, tv := range []*TargetVariable{
+		{Target: "debug", Name: "CFLAGS", Op: AssignAppend, Value: "-g"},
+		{Target: "debug", Name: "LEVEL", Op: AssignAppend, Value: "$(CFLAGS)"},
+		{Target: "debug", Name: "CC", Op: AssignRecursive, Value: "gcc"},
+		{Target: "main.o", Name: "CC", Op: AssignAppend, Value: "-m32"},
+		{Target: "%.o", Name: "CFLAGS", Op: AssignAppend, Value: "-fPIC"},
+		{Target: "main.o", Name: "MODE", Op: AssignConditional, Value: "object"},
+		{Target: "main.o", Name: "CFLAGS", Op: AssignSimple, Value: "$(LEVEL)"},
+		{Target: "debug", Name: "OPT", Op: AssignAppend, Value: "-g"},
+		{Target: "main.o", Name: "OPT", Op: AssignRecursive, Value: "-O3"},
+	} {
+		tv.Origin = OriginFile
+		if err := mf.AddTargetVariable(tv); err != nil {
+			t.Fatalf("AddTargetVariable failed: %v", err)
+		}
+	}
+
+	debug, err := mf.NewScope("debug", nil)
+	if err != nil {
+		t.Fatalf("NewScope failed: %v", err)
+	}
+	// main.o is built on behalf of debug and inherits its variables; the
+	// target-specific := was expanded where it was written
+	main, err := mf.NewScope("main.o", debug)
+	if err != nil {
+		t.Fatalf("NewScope failed: %v", err)
+	}
+	other, err := mf.NewScope("other", nil)
+	if err != nil {
+		t.Fatalf("NewScope failed: %v", err)
+	}
+	if other != nil {
+		t.Error("Expected no scope for a target without variables")
+	}
+
+	tests := []struct {
+		scope *Scope
+		text  string
+		want  string
+	}{
+		{nil, "$(CFLAGS) $(LEVEL)", "-O2 1"},
+		{debug, "$(CFLAGS) $(LEVEL)", "-O2 -g 1 -O2 -g"},
+		{debug, "$(CC) $(origin CC)", "clang command line"},
+		// An append does not add to a command line variable
+		{main, "$(CFLAGS) $(MODE) $(CC) $(origin CC)", "1 object clang command line"},
+		// An append adds to an override; an assignment does not replace it
+		{debug, "$(OPT) $(origin OPT)", "-O -g override"},
+		{main, "$(OPT)", "-O -g"},
+	}
+	for _, test := range tests {
+		got, err := mf.ExpandInScope(test.text, test.scope, nil, Position{})
+		if err != nil || got != test.want {
+			t.Errorf("ExpandInScope(%q) = %q, %v, want %q", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestRecipeEnvironment(t *testing.T) {
+	t.Setenv("GOMAKE_FROM_ENV", "env")
+	t.Setenv("GOMAKE_HIDDEN", "secret")
+
+	mf := NewMakefile()
+	mf.Assign("GOMAKE_FROM_ENV", AssignRecursive, "file")
+	mf.Assign("GOMAKE_PLAIN", AssignRecursive, "plain")
+	mf.Assign("GOMAKE_EXPORTED", AssignRecursive, "$(GOMAKE_PLAIN)!")
+	mf.Export("GOMAKE_EXPORTED", true)
+	mf.Export("GOMAKE_HIDDEN", false)
+	mf.AssignOrigin("GOMAKE_CMDLINE", AssignRecursive, "cmd", OriginCommandLine)
+	mf.DefineOrigin("GOMAKE_DEFAULT", "default", Recursive, OriginDefault)
+	mf.AddTargetVariable(&TargetVariable{Target: "t", Name: "GOMAKE_TARGET", Op: AssignRecursive, Value: "target", Origin: OriginFile, Export: true})
+
+	scope, _ := mf.NewScope("t", nil)
+	env, err := mf.RecipeEnvironment(scope)
+	if err != nil {
+		t.Fatalf("RecipeEnvironment failed: %v", err)
+	}
+	lookup := func(name string) (string, bool) {
+		for _, entry := range env {
+			if value, ok := strings.CutPrefix(entry, name+"="); ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+
+	for name, want := range map[string]string{
+		"GOMAKE_FROM_ENV": "file",
+		"GOMAKE_EXPORTED": "plain!",
+		"GOMAKE_CMDLINE":  "cmd",
+		"GOMAKE_TARGET":   "target",
+	} {
+		if got, ok := lookup(name); !ok || got != want {
+			t.Errorf("%s = %q (set %v), want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"GOMAKE_PLAIN", "GOMAKE_HIDDEN", "GOMAKE_DEFAULT"} {
+		if value, ok := lookup(name); ok {
+			t.Errorf("%s should not be exported, got %q", name, value)
+		}
+	}
+
+	mf.ExportAll = true
+	env, _ = mf.RecipeEnvironment(nil)
+	if got, ok := lookup("GOMAKE_PLAIN"); !ok || got != "plain" {
+		t.Errorf("Expected GOMAKE_PLAIN to be exported with ExportAll, got %q", got)
+	}
+	if _, ok := lookup("GOMAKE_HIDDEN"); ok {
+		t.Error("unexport should win over ExportAll")
+	}
+}
+
+func TestScopePrivateVariables(t *testing.T) {
+	mf := NewMakefile()
+	mf.Assign("GLOBAL", AssignRecursive, "global")
+	mf.MarkPrivate("GLOBAL", OriginFile)
+	mf.Assign("LDFLAGS", AssignRecursive, "-L.")
+	if err := mf.AddTargetVariable(&TargetVariable{Target: "lib", Name: "LDFLAGS", Op: AssignRecursive, Value: "-shared", Origin: OriginFile, Private: true}); err != nil {
+		t.Fatalf("AddTargetVariable failed: %v", err)
+	}
+
+	lib, err := mf.NewScope("lib", nil)
+	if err != nil {
+		t.Fatalf("NewScope failed: %v", err)
+	}
+	dep, err := mf.NewScope("dep.o", lib)
+	if err != nil {
+		t.Fatalf("NewScope failed: %v", err)
+	}
+	if dep == lib {
+		t.Fatal("Expected prerequisites of lib to get a scope of their own")
+	}
+
+	tests := []struct {
+		scope *Scope
+		text  string
+		want  string
+	}{
+		{lib, "$(LDFLAGS)", "-shared"},
+		{dep, "$(LDFLAGS)", "-L."},
+		{nil, "[$(GLOBAL)]", "[]"},
+	}
+	for _, tt := range tests {
+		got, err := mf.ExpandInScope(tt.text, tt.scope, nil, Position{})
+		if err != nil {
+			t.Fatalf("ExpandInScope(%q) failed: %v", tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("ExpandInScope(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if got := mf.ExpandVariables("$(GLOBAL)"); got != "global" {
+		t.Errorf("Expected private global to be visible outside recipes, got %q", got)
+	}
+}
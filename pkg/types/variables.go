@@ -1,35 +1,227 @@
 package types
 
-import (
-	"os"
-	"regexp"
-	"strings"
-)
-
-// Variable reference patterns: $(VAR), ${VAR}, and automatic variables
-var (
-	varPattern1 = regexp.MustCompile(`\$\(([^)]+)\)`)  // $(VAR)
-	varPattern2 = regexp.MustCompile(`\$\{([^}]+)\}`)  // ${VAR}
-	autoVarPattern = regexp.MustCompile(`\$[@<^?]`)    // $@, $<, $^, $?
-)
+import "strings"
 
 // AutomaticVariables holds the context for automatic variables in a build rule.
+// Every variable can also be written in parenthesised form, such as $(@), and
+// has a D variant giving the directory part and an F variant giving the file
+// part of each word, such as $(@D) and $(^F). $| has no D and F variants.
 type AutomaticVariables struct {
-	Target         string   // $@ - the target name
-	FirstPrereq    string   // $< - the first prerequisite
-	AllPrereqs     []string // $^ - all prerequisites (space-separated)
-	NewerPrereqs   []string // $? - prerequisites newer than target
+	Target       string   // $@ - the target name
+	FirstPrereq  string   // $< - the first prerequisite
+	AllPrereqs   []string // $^ - all prerequisites without duplicates; $+ keeps them
+	NewerPrereqs []string // $? - prerequisites newer than target
+	OrderOnly    []string // $| - order-only prerequisites
+	Stem         string   // $* - the stem matched by a pattern rule
+	Member       string   // $% - the member name when the target is an archive member
 }
 
-// ToString converts automatic variable lists to space-separated strings.
+// AllPrereqsString returns the value of $^: the prerequisites separated by
+// spaces, each listed once.
 func (av *AutomaticVariables) AllPrereqsString() string {
-	return strings.Join(av.AllPrereqs, " ")
+	return strings.Join(uniqueWords(av.AllPrereqs), " ")
 }
 
+// NewerPrereqsString returns the value of $?: the prerequisites newer than
+// the target, separated by spaces, each listed once.
 func (av *AutomaticVariables) NewerPrereqsString() string {
-	return strings.Join(av.NewerPrereqs, " ")
+	return strings.Join(uniqueWords(av.NewerPrereqs), " ")
+}
+
+// lookup returns the value of the automatic variable with the given name
+// (without the leading $), and whether name is an automatic variable.
+func (av *AutomaticVariables) lookup(name string) (string, bool) {
+	if len(name) == 0 || len(name) > 2 {
+		return "", false
+	}
+
+	var words []string
+	switch name[0] {
+	case '@':
+		words = []string{av.Target}
+	case '<':
+		words = []string{av.FirstPrereq}
+	case '^':
+		words = uniqueWords(av.AllPrereqs)
+	case '+':
+		words = av.AllPrereqs
+	case '?':
+		words = uniqueWords(av.NewerPrereqs)
+	case '*':
+		words = []string{av.Stem}
+	case '%':
+		words = []string{av.Member}
+	case '|':
+		if len(name) == 2 {
+			return "", false
+		}
+		words = uniqueWords(av.OrderOnly)
+	default:
+		return "", false
+	}
+
+	if len(name) == 2 {
+		var part func(string) string
+		switch name[1] {
+		case 'D':
+			part = dirPart
+		case 'F':
+			part = filePart
+		default:
+			return "", false
+		}
+		parts := make([]string, 0, len(words))
+		for _, word := range words {
+			if word != "" {
+				parts = append(parts, part(word))
+			}
+		}
+		words = parts
+	}
+	return strings.Join(words, " "), true
+}
+
+// dirPart returns the directory part of a file name without the trailing
+// slash, or "." if it has none, as used by the D automatic variables.
+func dirPart(name string) string {
+	slash := strings.LastIndexByte(name, '/')
+	switch {
+	case slash < 0:
+		return "."
+	case slash == 0:
+		return "/"
+	}
+	return name[:slash]
+}
+
+// filePart returns the part of a file name after the last slash, as used by
+// the F automatic variables.
+func filePart(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
+
+// uniqueWords returns words with duplicates removed, keeping the first
+// occurrence of each.
+func uniqueWords(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	unique := make([]string, 0, len(words))
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			unique = append(unique, word)
+		}
+	}
+	return unique
+}
+
+// Flavor describes how a variable's value is expanded.
+type Flavor int
+
+const (
+	// Recursive variables (VAR = value) store their value unexpanded and
+	// expand it every time they are referenced.
+	Recursive Flavor = iota
+
+	// Simple variables (VAR := value) are expanded once, when assigned.
+	Simple
+)
+
+// String returns the name $(flavor) reports for the flavor.
+func (f Flavor) String() string {
+	if f == Simple {
+		return "simple"
+	}
+	return "recursive"
+}
+
+// Variable is a variable definition stored in a Makefile.
+type Variable struct {
+	// Name is the variable name
+	Name string
+
+	// Value is the stored value: unexpanded for recursive variables,
+	// already expanded for simple ones
+	Value string
+
+	// Flavor determines whether Value is expanded when referenced
+	Flavor Flavor
+
+	// Origin records where the variable was defined
+	Origin Origin
+
+	// Pos is where the variable was defined, if it was defined while
+	// reading a Makefile
+	Pos Position
+
+	// Private is set for variables defined with the private modifier. A
+	// private variable is not inherited: a global one is invisible to
+	// recipes, and a target-specific one to the target's prerequisites.
+	Private bool
 }
 
+// Origin records where a variable was defined. Origins are ordered by
+// precedence: a definition never replaces one with a higher origin.
+type Origin int
+
+const (
+	// OriginUndefined is reported for variables that are not defined.
+	OriginUndefined Origin = iota
+
+	// OriginDefault is a built-in default such as CC.
+	OriginDefault
+
+	// OriginEnvironment is inherited from the environment.
+	OriginEnvironment
+
+	// OriginFile is defined in a Makefile.
+	OriginFile
+
+	// OriginEnvironmentOverride is inherited from the environment while
+	// environment overrides (-e) are enabled.
+	OriginEnvironmentOverride
+
+	// OriginCommandLine is defined on the command line.
+	OriginCommandLine
+
+	// OriginOverride is defined with the override directive.
+	OriginOverride
+
+	// OriginAutomatic is an automatic variable or one bound by $(foreach)
+	// or $(call).
+	OriginAutomatic
+)
+
+// String returns the name $(origin) reports for the origin.
+func (o Origin) String() string {
+	switch o {
+	case OriginDefault:
+		return "default"
+	case OriginEnvironment:
+		return "environment"
+	case OriginFile:
+		return "file"
+	case OriginEnvironmentOverride:
+		return "environment override"
+	case OriginCommandLine:
+		return "command line"
+	case OriginOverride:
+		return "override"
+	case OriginAutomatic:
+		return "automatic"
+	}
+	return "undefined"
+}
+
+// Assignment operators recognised by ParseAssignment and Makefile.Assign.
+const (
+	AssignRecursive   = "="
+	AssignSimple      = ":="
+	AssignPosix       = "::="
+	AssignConditional = "?="
+	AssignAppend      = "+="
+	AssignShell       = "!="
+)
+
 // expandVariables expands variable references in text using the provided variable map.
 // It supports both $(VAR) and ${VAR} syntax and falls back to environment variables.
 func expandVariables(text string, variables map[string]string) string {
@@ -38,75 +230,71 @@ func expandVariables(text string, variables map[string]string) string {
 
 // expandVariablesWithContext expands variable references including automatic variables.
 func expandVariablesWithContext(text string, variables map[string]string, autoVars *AutomaticVariables) string {
-	// Replace automatic variables first ($@, $<, $^, $?)
-	if autoVars != nil {
-		text = autoVarPattern.ReplaceAllStringFunc(text, func(match string) string {
-			switch match {
-			case "$@":
-				return autoVars.Target
-			case "$<":
-				return autoVars.FirstPrereq
-			case "$^":
-				return autoVars.AllPrereqsString()
-			case "$?":
-				return autoVars.NewerPrereqsString()
-			default:
-				return match // shouldn't happen with our regex
-			}
-		})
+	makefile := NewMakefile()
+	for name, value := range variables {
+		makefile.SetVariable(name, value)
 	}
+	return makefile.ExpandVariablesWithContext(text, autoVars)
+}
 
-	// Replace $(VAR) patterns
-	text = varPattern1.ReplaceAllStringFunc(text, func(match string) string {
-		// Extract variable name from $(VAR)
-		varName := match[2 : len(match)-1] // Remove $( and )
-		return getVariableValue(varName, variables)
-	})
-
-	// Replace ${VAR} patterns
-	text = varPattern2.ReplaceAllStringFunc(text, func(match string) string {
-		// Extract variable name from ${VAR}
-		varName := match[2 : len(match)-1] // Remove ${ and }
-		return getVariableValue(varName, variables)
-	})
-
-	return text
-}
-
-// getVariableValue looks up a variable value, first in the provided map,
-// then in environment variables.
-func getVariableValue(name string, variables map[string]string) string {
-	// First check Makefile variables
-	if value, exists := variables[name]; exists {
-		return value
+// ParseAssignment parses a variable assignment line such as "VAR = value",
+// "VAR := value", "VAR ?= value", "VAR += value" or "VAR != command". It
+// returns the variable name, the assignment operator, the value, and whether
+// the line is an assignment at all. An '=' inside a variable reference does not count,
+// so rule lines such as "all: $(SRC:.c=.o)" are not assignments.
+func ParseAssignment(line string) (name, op, value string, isAssignment bool) {
+	eq := indexUnnested(line, '=')
+	if eq < 0 {
+		return "", "", "", false
 	}
 
-	// Fall back to environment variables
-	return os.Getenv(name)
-}
-
-// ParseVariableAssignment parses a variable assignment line like "VAR = value"
-// Returns the variable name, value, and whether it was a valid assignment.
-func ParseVariableAssignment(line string) (name, value string, isAssignment bool) {
-	// Look for = sign (supporting spaces around it)
-	parts := strings.SplitN(line, "=", 2)
-	if len(parts) != 2 {
-		return "", "", false
+	lhs := line[:eq]
+	op = AssignRecursive
+	switch {
+	case strings.HasSuffix(lhs, "::"):
+		op = AssignPosix
+	case strings.HasSuffix(lhs, ":"):
+		op = AssignSimple
+	case strings.HasSuffix(lhs, "?"):
+		op = AssignConditional
+	case strings.HasSuffix(lhs, "+"):
+		op = AssignAppend
+	case strings.HasSuffix(lhs, "!"):
+		op = AssignShell
 	}
 
-	name = strings.TrimSpace(parts[0])
-	value = strings.TrimSpace(parts[1])
+	name = strings.TrimSpace(lhs[:len(lhs)-len(op)+1])
+	value = strings.TrimSpace(line[eq+1:])
 
 	// Variable names should be valid identifiers (letters, digits, underscore)
 	if name == "" || strings.ContainsAny(name, " \t:") {
+		return "", "", "", false
+	}
+
+	return name, op, value, true
+}
+
+// SplitRule splits a rule line at the first colon that is not inside a
+// variable reference, returning the targets and the text after the colon.
+// ok is false if the line has no such colon.
+func SplitRule(line string) (targets, rest string, ok bool) {
+	colon := indexUnnested(line, ':')
+	if colon < 0 {
 		return "", "", false
 	}
+	return line[:colon], line[colon+1:], true
+}
 
-	return name, value, true
+// ParseVariableAssignment parses a variable assignment line like "VAR = value"
+// Returns the variable name, value, and whether it was a valid assignment.
+// The assignment operator is accepted but not reported; see ParseAssignment.
+func ParseVariableAssignment(line string) (name, value string, isAssignment bool) {
+	name, _, value, isAssignment = ParseAssignment(line)
+	return name, value, isAssignment
 }
 
 // IsVariableAssignment returns true if the line looks like a variable assignment.
 func IsVariableAssignment(line string) bool {
 	_, _, isAssignment := ParseVariableAssignment(line)
 	return isAssignment
-}
\ No newline at end of file
+}
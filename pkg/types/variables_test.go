@@ -201,4 +201,143 @@ func TestMakefileVariableMethods(t *testing.T) {
 	if expanded != expected {
 		t.Errorf("ExpandVariables returned %q, want %q", expanded, expected)
 	}
-}
\ No newline at end of file
+}
+
+func TestAutomaticVariables(t *testing.T) {
+	autoVars := &AutomaticVariables{
+		Target:       "build/app",
+		FirstPrereq:  "src/main.o",
+		AllPrereqs:   []string{"src/main.o", "util.o", "src/main.o"},
+		NewerPrereqs: []string{"util.o"},
+		OrderOnly:    []string{"build"},
+		Stem:         "src/main",
+		Member:       "lib/member.o",
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$@ $(@) ${@}", "build/app build/app build/app"},
+		{"$<", "src/main.o"},
+		{"$^", "src/main.o util.o"},
+		{"$+", "src/main.o util.o src/main.o"},
+		{"$?", "util.o"},
+		{"$|", "build"},
+		{"$*", "src/main"},
+		{"$%", "lib/member.o"},
+		{"$(@D) $(@F)", "build app"},
+		{"$(<D) $(<F)", "src main.o"},
+		{"$(^D)", "src ."},
+		{"$(^F)", "main.o util.o"},
+		{"$(+F)", "main.o util.o main.o"},
+		{"$(?D) $(?F)", ". util.o"},
+		{"$(*D) $(*F)", "src main"},
+		{"$(%D) $(%F)", "lib member.o"},
+	}
+
+	mf := NewMakefile()
+	for _, test := range tests {
+		result, err := mf.ExpandWithContext(test.input, autoVars)
+		if err != nil {
+			t.Fatalf("ExpandWithContext(%q) failed: %v", test.input, err)
+		}
+		if result != test.expected {
+			t.Errorf("ExpandWithContext(%q) = %q, want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestVariablePrecedence(t *testing.T) {
+	os.Setenv("GOMAKE_PRECEDENCE_ENV", "from-env")
+	defer os.Unsetenv("GOMAKE_PRECEDENCE_ENV")
+
+	mf := NewMakefile()
+	mf.DefineOrigin("CC", "cc", Recursive, OriginDefault)
+	mf.DefineOrigin("MODE", "release", Recursive, OriginCommandLine)
+	mf.DefineOrigin("GOMAKE_PRECEDENCE_ENV", "default", Recursive, OriginDefault)
+
+	mf.Assign("CC", AssignRecursive, "gcc")
+	mf.Assign("MODE", AssignRecursive, "debug")
+	mf.Assign("MODE", AssignAppend, "extra")
+	mf.AssignOrigin("FORCED", AssignRecursive, "cmd", OriginCommandLine)
+	mf.AssignOrigin("FORCED", AssignRecursive, "forced", OriginOverride)
+
+	tests := []struct {
+		name   string
+		value  string
+		origin Origin
+	}{
+		{"CC", "gcc", OriginFile},
+		{"MODE", "release", OriginCommandLine},
+		{"FORCED", "forced", OriginOverride},
+		{"GOMAKE_PRECEDENCE_ENV", "from-env", OriginEnvironment},
+		{"GOMAKE_UNDEFINED_VARIABLE", "", OriginUndefined},
+	}
+
+	for _, test := range tests {
+		if value, _ := mf.Expand("$(" + test.name + ")"); value != test.value {
+			t.Errorf("$(%s) = %q, want %q", test.name, value, test.value)
+		}
+		if origin := mf.Origin(test.name); origin != test.origin {
+			t.Errorf("Origin(%s) = %s, want %s", test.name, origin, test.origin)
+		}
+		if origin, _ := mf.Expand("$(origin " + test.name + ")"); origin != test.origin.String() {
+			t.Errorf("$(origin %s) = %q, want %q", test.name, origin, test.origin)
+		}
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	os.Setenv("GOMAKE_PRECEDENCE_ENV", "from-env")
+	defer os.Unsetenv("GOMAKE_PRECEDENCE_ENV")
+
+	mf := NewMakefile()
+	mf.Assign("GOMAKE_PRECEDENCE_ENV", AssignRecursive, "from-file")
+	if value, _ := mf.Expand("$(GOMAKE_PRECEDENCE_ENV)"); value != "from-file" {
+		t.Errorf("Without -e the Makefile should win, got %q", value)
+	}
+
+	mf = NewMakefile()
+	mf.EnvironmentOverrides = true
+	mf.Assign("GOMAKE_PRECEDENCE_ENV", AssignRecursive, "from-file")
+	if value, _ := mf.Expand("$(GOMAKE_PRECEDENCE_ENV)"); value != "from-env" {
+		t.Errorf("With -e the environment should win, got %q", value)
+	}
+	if origin := mf.Origin("GOMAKE_PRECEDENCE_ENV"); origin != OriginEnvironmentOverride {
+		t.Errorf("Origin = %s, want %s", origin, OriginEnvironmentOverride)
+	}
+
+	mf.AssignOrigin("GOMAKE_PRECEDENCE_ENV", AssignRecursive, "from-override", OriginOverride)
+	if value, _ := mf.Expand("$(GOMAKE_PRECEDENCE_ENV)"); value != "from-override" {
+		t.Errorf("override should beat -e, got %q", value)
+	}
+}
+
+func TestDefineDefaults(t *testing.T) {
+	t.Setenv("SHELL", "/bin/false")
+
+	mf := NewMakefile()
+	mf.Assign("CC", AssignRecursive, "clang")
+	mf.DefineDefaults()
+	mf.DefineOrigin("SHELL", DefaultShell, Recursive, OriginDefault)
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"$(CC)", "clang"},
+		{"$(origin CC)", "file"},
+		{"$(CXX)", "g++"},
+		{"$(origin CXX)", "default"},
+		{"$(COMPILE.c)", "clang    -c"},
+		{"$(origin CFLAGS)", "undefined"},
+		// SHELL is never taken from the environment
+		{"$(SHELL)", DefaultShell},
+	}
+	for _, test := range tests {
+		if got, _ := mf.Expand(test.text); got != test.want {
+			t.Errorf("%s = %q, want %q", test.text, got, test.want)
+		}
+	}
+}